@@ -34,10 +34,26 @@ func Connect() error {
 
 	log.Println("Database connected successfully")
 
-	if err := DB.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}); err != nil {
+	if err := DB.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}, &models.RenderCache{}, &models.UsageRecord{}, &models.StoredObject{}, &models.ShareLink{}, &models.Workspace{}, &models.WorkspaceMember{}, &models.MessageRevision{}, &models.Rating{}, &models.PromptTemplate{}, &models.PromptExperiment{}, &models.PromptVariant{}, &models.GenerationAttempt{}, &models.QuotaReservation{}); err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	// Emails used to be unique across soft-deleted users too; the partial
+	// idx_users_active_email replaces it.
+	if err := DB.Exec(`DROP INDEX IF EXISTS idx_users_email`).Error; err != nil {
+		return fmt.Errorf("failed to drop old email index: %w", err)
+	}
+
+	searchIndexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_chats_title_search ON chats USING GIN (to_tsvector('english', title))`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (to_tsvector('english', coalesce(content, '') || ' ' || coalesce(explanation, '')))`,
+	}
+	for _, statement := range searchIndexes {
+		if err := DB.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to create search index: %w", err)
+		}
+	}
+
 	log.Println("Database migration completed")
 	return nil
 }
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/prompts"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+	"gorm.io/gorm"
+)
+
+type RegenerateRequest struct {
+	Quality     string `json:"quality" binding:"omitempty,oneof=low medium high"`
+	Format      string `json:"format" binding:"omitempty,oneof=mp4 webm gif mov"`
+	Style       string `json:"style" binding:"omitempty,oneof=3b1b whiteboard chalkboard high-contrast brand"`
+	Complexity  string `json:"complexity" binding:"omitempty,oneof=simple moderate complex"`
+	MinDuration int    `json:"min_duration" binding:"omitempty,min=5,max=1800"`
+	MaxDuration int    `json:"max_duration" binding:"omitempty,min=5,max=1800"`
+}
+
+type BranchResponse struct {
+	ParentID     string            `json:"parent_id"`
+	SelectedID   string            `json:"selected_id"`
+	Alternatives []MessageResponse `json:"alternatives"`
+}
+
+func toMessageResponse(msg models.Message) MessageResponse {
+	return MessageResponse{
+		ID:              msg.ID,
+		Role:            msg.Role,
+		Content:         msg.Content,
+		VideoURL:        msg.VideoURL,
+		ThumbnailURL:    msg.ThumbnailURL,
+		Explanation:     msg.Explanation,
+		ExplanationData: msg.ExplanationData,
+		Duration:        msg.Duration,
+		Code:            msg.Code,
+		Revision:        msg.Revision,
+		Style:           msg.Style,
+		Complexity:      msg.Complexity,
+		ReusedFromID:    msg.ReusedFromID,
+		ParentID:        msg.ParentID,
+		CreatedAt:       msg.CreatedAt,
+	}
+}
+
+// selectedReplies groups replies by the prompt they answer and picks the one
+// shown for each: the selected reply, or its newest sibling if that was deleted.
+func selectedReplies(all []models.Message) (map[string][]models.Message, map[string]string) {
+	siblings := map[string][]models.Message{}
+	for _, msg := range all {
+		if msg.Role == "assistant" && msg.ParentID != "" {
+			siblings[msg.ParentID] = append(siblings[msg.ParentID], msg)
+		}
+	}
+
+	selected := map[string]string{}
+	for parentID, group := range siblings {
+		selected[parentID] = group[len(group)-1].ID
+		for _, msg := range group {
+			if msg.Selected {
+				selected[parentID] = msg.ID
+			}
+		}
+	}
+	return siblings, selected
+}
+
+// conversationPath filters a chat's messages (oldest first) down to the
+// conversation along the selected replies, as exports and shares show it.
+func conversationPath(all []models.Message) []models.Message {
+	_, selected := selectedReplies(all)
+	var path []models.Message
+	for _, msg := range all {
+		if msg.Role == "assistant" && msg.ParentID != "" && selected[msg.ParentID] != msg.ID {
+			continue
+		}
+		path = append(path, msg)
+	}
+	return path
+}
+
+// buildBranches turns a chat's messages (oldest first) into the conversation
+// along the selected replies, plus every prompt that has alternative replies.
+// If the selected reply of a prompt was deleted, its newest sibling stands in.
+func buildBranches(all []models.Message) ([]MessageResponse, []BranchResponse) {
+	siblings, selected := selectedReplies(all)
+
+	var messages []MessageResponse
+	var branches []BranchResponse
+	for _, msg := range all {
+		if msg.Role == "assistant" && msg.ParentID != "" {
+			if selected[msg.ParentID] != msg.ID {
+				continue
+			}
+			group := siblings[msg.ParentID]
+			response := toMessageResponse(msg)
+			response.BranchCount = len(group)
+			alternatives := make([]MessageResponse, len(group))
+			for i, sibling := range group {
+				alternatives[i] = toMessageResponse(sibling)
+				alternatives[i].BranchIndex, alternatives[i].BranchCount = i+1, len(group)
+				if sibling.ID == msg.ID {
+					response.BranchIndex = i + 1
+				}
+			}
+			messages = append(messages, response)
+			if len(group) > 1 {
+				branches = append(branches, BranchResponse{ParentID: msg.ParentID, SelectedID: msg.ID, Alternatives: alternatives})
+			}
+			continue
+		}
+		messages = append(messages, toMessageResponse(msg))
+	}
+
+	if messages == nil {
+		messages = []MessageResponse{}
+	}
+	return messages, branches
+}
+
+// attachLegacyReplies links replies saved before branching existed to the
+// prompt they answer. Generated replies repeat their prompt as content, which
+// keeps direct renders and other unrelated messages out.
+func attachLegacyReplies(chatID string) error {
+	var messages []models.Message
+	if err := database.DB.Where("chat_id = ?", chatID).Order("created_at ASC").Find(&messages).Error; err != nil {
+		return err
+	}
+
+	var prompt models.Message
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			prompt = msg
+			continue
+		}
+		if msg.ParentID != "" || prompt.ID == "" || msg.Content != prompt.Content {
+			continue
+		}
+		if err := database.DB.Model(&msg).UpdateColumn("parent_id", prompt.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func selectBranch(tx *gorm.DB, message models.Message) error {
+	if err := tx.Model(&models.Message{}).
+		Where("parent_id = ? AND id <> ?", message.ParentID, message.ID).
+		UpdateColumn("selected", false).Error; err != nil {
+		return err
+	}
+	return tx.Model(&message).UpdateColumn("selected", true).Error
+}
+
+// findPrompt resolves the user message a regenerate request refers to; the
+// route accepts either the prompt itself or any of its replies.
+func findPrompt(c *gin.Context, messageID string, user models.User) (models.Message, bool) {
+	var message models.Message
+	if err := database.DB.Where("id = ?", messageID).First(&message).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return message, false
+	}
+	if _, ok := findAccessibleChat(c, database.DB, message.ChatID, user, RoleEditor); !ok {
+		return message, false
+	}
+	if err := attachLegacyReplies(message.ChatID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load conversation"})
+		return message, false
+	}
+	if message.Role == "user" {
+		return message, true
+	}
+
+	if message.ParentID == "" {
+		if err := database.DB.Where("id = ?", message.ID).First(&message).Error; err != nil || message.ParentID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message has no prompt to regenerate"})
+			return message, false
+		}
+	}
+
+	var prompt models.Message
+	if err := database.DB.Where("id = ?", message.ParentID).First(&prompt).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "prompt not found"})
+		return prompt, false
+	}
+	return prompt, true
+}
+
+func RegenerateMessage(c *gin.Context) {
+	var req RegenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	override := complexityOverride{Complexity: req.Complexity, MinDuration: req.MinDuration, MaxDuration: req.MaxDuration}
+	if !override.valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_duration must not exceed max_duration"})
+		return
+	}
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	prompt, ok := findPrompt(c, c.Param("id"), user)
+	if !ok {
+		return
+	}
+
+	renderOpts := utils.RenderOptions{Quality: req.Quality, Format: req.Format, Style: resolveStyle(req.Style, user)}.WithDefaults()
+	if _, ok := enforceQuota(c, user, renderOpts); !ok {
+		return
+	}
+
+	usage := models.UsageRecord{UserID: user.ID}
+	defer recordUsage(&usage)
+
+	result, err := generateAnimation(c.Request.Context(), prompt.Content, renderOpts, prompts.Resolve(user.ID), override, &usage, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := saveGeneration(prompt.ChatID, prompt.ID, prompt.Content, promptCacheKey(prompt.Content, renderOpts), result, &usage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save response"})
+		return
+	}
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return selectBranch(tx, message)
+	}); err != nil {
+		fmt.Printf("Warning: failed to select regenerated message %s: %v\n", message.ID, err)
+	}
+
+	c.JSON(http.StatusOK, ChatResponse{
+		ChatID:          prompt.ChatID,
+		MessageID:       message.ID,
+		VideoURL:        result.VideoURL,
+		ThumbnailURL:    result.ThumbnailURL,
+		Explanation:     result.Explanation,
+		ExplanationData: result.ExplanationData,
+		Duration:        result.Duration,
+		ParentID:        prompt.ID,
+		Target:          &result.Target,
+		CreatedAt:       message.CreatedAt,
+	})
+}
+
+func SelectMessage(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	message, ok := findAccessibleMessage(c, c.Param("id"), user, RoleEditor)
+	if !ok {
+		return
+	}
+	if message.ParentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message has no alternatives"})
+		return
+	}
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return selectBranch(tx, message)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to select message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "message selected", "id": message.ID, "parent_id": message.ParentID})
+}
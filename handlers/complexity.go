@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"google.golang.org/genai"
+)
+
+const (
+	complexitySimple   = "simple"
+	complexityModerate = "moderate"
+	complexityComplex  = "complex"
+)
+
+var complexityLevels = []string{complexitySimple, complexityModerate, complexityComplex}
+
+// defaultMinDuration is the shortest video accepted when the request doesn't
+// set its own bounds.
+const defaultMinDuration = 60
+
+var complexityDurations = map[string][2]int{
+	complexitySimple:   {60, 120},
+	complexityModerate: {120, 240},
+	complexityComplex:  {240, 600},
+}
+
+const classifierPrompt = `Classify how much explanation this request for an educational math/science animation needs.
+
+- simple: one idea or one worked example, about 1-2 minutes
+- moderate: a concept with a few steps or examples, about 2-4 minutes
+- complex: a multi-step derivation or proof, or several connected ideas, about 4-10 minutes
+
+Mentioning an advanced field does not make a request complex on its own; judge the scope of what is asked.
+%s
+Answer with exactly one word: simple, moderate or complex.
+
+Request: %s`
+
+// ComplexityRule adjusts the classifier for prompts matching Pattern. Mode
+// "force" skips the LLM entirely. "min" and "max" are passed to the LLM as
+// hints only, and bound the level when the rules decide on their own.
+type ComplexityRule struct {
+	Pattern string `json:"pattern"`
+	Level   string `json:"level"`
+	Mode    string `json:"mode"`
+
+	re *regexp.Regexp
+}
+
+var defaultComplexityRules = []ComplexityRule{
+	{Pattern: `\b(prove|proof|derive|derivation)\b`, Level: complexityModerate, Mode: "min"},
+	{Pattern: `\b(step[- ]by[- ]step|in depth|in detail|from scratch)\b`, Level: complexityModerate, Mode: "min"},
+	// Only explicit requests for brevity; "simple harmonic motion" or "short
+	// exact sequence" say nothing about length.
+	{Pattern: `\b(keep it (short|brief|simple|quick)|briefly|in a nutshell|(quick|brief|short) (overview|intro|introduction|explanation|summary|video|animation))\b`, Level: complexitySimple, Mode: "max"},
+}
+
+var complexityRules []ComplexityRule
+
+var complexityLLMEnabled = true
+
+// LoadComplexityRulesFromEnv reads classifier rules from the JSON file in
+// COMPLEXITY_RULES_FILE, falling back to the built-in rules. Setting
+// COMPLEXITY_LLM=false classifies with the rules alone.
+func LoadComplexityRulesFromEnv() error {
+	complexityLLMEnabled = os.Getenv("COMPLEXITY_LLM") != "false"
+
+	rules := defaultComplexityRules
+	if path := os.Getenv("COMPLEXITY_RULES_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rules = nil
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	compiled := make([]ComplexityRule, 0, len(rules))
+	for i, rule := range rules {
+		if !slices.Contains(complexityLevels, rule.Level) {
+			return fmt.Errorf("complexity rule %d: unknown level %q", i+1, rule.Level)
+		}
+		if rule.Mode != "force" && rule.Mode != "min" && rule.Mode != "max" {
+			return fmt.Errorf("complexity rule %d: mode must be force, min or max", i+1)
+		}
+		re, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("complexity rule %d: %w", i+1, err)
+		}
+		rule.re = re
+		compiled = append(compiled, rule)
+	}
+	complexityRules = compiled
+	return nil
+}
+
+// durationTarget is the length a generation aims for. Min is enforced after
+// rendering, and Max too when the caller asked for it explicitly.
+type durationTarget struct {
+	Complexity string `json:"complexity"`
+	Source     string `json:"source"`
+	Min        int    `json:"min_duration"`
+	Max        int    `json:"max_duration"`
+	EnforceMin int    `json:"-"`
+	EnforceMax int    `json:"-"`
+}
+
+type complexityOverride struct {
+	Complexity  string
+	MinDuration int
+	MaxDuration int
+}
+
+func (o complexityOverride) valid() bool {
+	return o.MinDuration == 0 || o.MaxDuration == 0 || o.MinDuration <= o.MaxDuration
+}
+
+// accepts reports whether an existing video satisfies the requested bounds.
+func (o complexityOverride) accepts(duration int) bool {
+	return duration >= o.MinDuration && (o.MaxDuration == 0 || duration <= o.MaxDuration)
+}
+
+func levelIndex(level string) int {
+	return slices.Index(complexityLevels, level)
+}
+
+func classifyWithLLM(ctx context.Context, prompt string, hints []string) (string, tokenUsage, error) {
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		return "", tokenUsage{}, fmt.Errorf("env not set")
+	}
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey})
+	if err != nil {
+		return "", tokenUsage{}, err
+	}
+
+	hint := ""
+	if len(hints) > 0 {
+		hint = "\nKeep in mind: " + strings.Join(hints, "; ") + ".\n"
+	}
+	temperature := float32(0)
+	result, err := client.Models.GenerateContent(ctx, generationModel, genai.Text(fmt.Sprintf(classifierPrompt, hint, prompt)),
+		&genai.GenerateContentConfig{Temperature: &temperature})
+	if err != nil {
+		return "", tokenUsage{}, err
+	}
+
+	usage := usageFromResponse(result)
+	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil || len(result.Candidates[0].Content.Parts) == 0 {
+		return "", usage, fmt.Errorf("unexpected response format")
+	}
+
+	answer := strings.ToLower(strings.Trim(strings.TrimSpace(result.Candidates[0].Content.Parts[0].Text), ".*`\"'"))
+	if !slices.Contains(complexityLevels, answer) {
+		return "", usage, fmt.Errorf("unexpected complexity %q", answer)
+	}
+	return answer, usage, nil
+}
+
+// ruleBounds combines the matched min and max rules into one range. When they
+// conflict, e.g. "keep it short" on a proof, the max wins: an explicit request
+// for brevity says more about the wanted length than the topic does.
+func ruleBounds(matched []ComplexityRule) (string, string) {
+	lo, hi := 0, len(complexityLevels)-1
+	for _, rule := range matched {
+		if rule.Mode == "min" {
+			lo = max(lo, levelIndex(rule.Level))
+		} else {
+			hi = min(hi, levelIndex(rule.Level))
+		}
+	}
+	return complexityLevels[min(lo, hi)], complexityLevels[hi]
+}
+
+// classifyComplexity asks the LLM how much depth the prompt needs, with the
+// matching rules as hints, and keeps its answer within the rules' bounds.
+// Without an LLM answer the rules alone decide, starting from the lowest
+// level they allow.
+func classifyComplexity(ctx context.Context, prompt string, usage *models.UsageRecord) (string, string) {
+	var matched []ComplexityRule
+	for _, rule := range complexityRules {
+		if rule.re.MatchString(prompt) {
+			if rule.Mode == "force" {
+				return rule.Level, "rule"
+			}
+			matched = append(matched, rule)
+		}
+	}
+	lo, hi := ruleBounds(matched)
+
+	if complexityLLMEnabled {
+		var hints []string
+		if lo != complexitySimple {
+			hints = append(hints, "this is probably at least "+lo)
+		}
+		if hi != complexityComplex {
+			hints = append(hints, "this is probably at most "+hi)
+		}
+		answer, llmUsage, err := classifyWithLLM(ctx, prompt, hints)
+		llmUsage.addTo(usage)
+		if err == nil {
+			clamped := complexityLevels[min(max(levelIndex(answer), levelIndex(lo)), levelIndex(hi))]
+			if clamped != answer {
+				return clamped, "llm+rules"
+			}
+			return answer, "llm"
+		}
+		fmt.Println("error classifying complexity:", err)
+	}
+	return lo, "rules"
+}
+
+// resolveDurationTarget combines the classified (or requested) complexity
+// with any explicit bounds from the request.
+func resolveDurationTarget(ctx context.Context, prompt string, override complexityOverride, usage *models.UsageRecord) durationTarget {
+	target := durationTarget{Complexity: override.Complexity, Source: "request"}
+	if target.Complexity == "" {
+		target.Complexity, target.Source = classifyComplexity(ctx, prompt, usage)
+	}
+
+	bounds := complexityDurations[target.Complexity]
+	target.Min, target.Max = bounds[0], bounds[1]
+	target.EnforceMin = defaultMinDuration
+
+	if override.MinDuration > 0 {
+		target.Min, target.EnforceMin = override.MinDuration, override.MinDuration
+		if target.Max < target.Min {
+			target.Max = target.Min + bounds[1] - bounds[0]
+		}
+	}
+	if override.MaxDuration > 0 {
+		target.Max, target.EnforceMax = override.MaxDuration, override.MaxDuration
+		if target.Min > target.Max {
+			target.Min = target.Max / 2
+			target.EnforceMin = min(target.EnforceMin, target.Min)
+		}
+	}
+	return target
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	providerName = "Feynman"
+	embedWidth   = 854
+	embedHeight  = 480
+)
+
+var shareLandingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} · {{.Provider}}</title>
+<meta name="description" content="{{.Description}}">
+<meta property="og:site_name" content="{{.Provider}}">
+<meta property="og:type" content="video.other">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.PageURL}}">
+{{- if .ThumbnailURL}}
+<meta property="og:image" content="{{.ThumbnailURL}}">
+{{- end}}
+<meta property="og:video" content="{{.VideoURL}}">
+<meta property="og:video:secure_url" content="{{.VideoURL}}">
+<meta property="og:video:type" content="{{.VideoType}}">
+<meta property="og:video:width" content="{{.Width}}">
+<meta property="og:video:height" content="{{.Height}}">
+<meta property="video:duration" content="{{.Duration}}">
+<meta name="twitter:card" content="player">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+{{- if .ThumbnailURL}}
+<meta name="twitter:image" content="{{.ThumbnailURL}}">
+{{- end}}
+<meta name="twitter:player" content="{{.EmbedURL}}">
+<meta name="twitter:player:width" content="{{.Width}}">
+<meta name="twitter:player:height" content="{{.Height}}">
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Title}}">
+<style>
+body { margin: 0 auto; max-width: 900px; padding: 24px; font-family: system-ui, sans-serif; background: #111; color: #eee; }
+video { width: 100%; border-radius: 8px; background: #000; }
+p { line-height: 1.6; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<video src="{{.VideoURL}}" {{if .ThumbnailURL}}poster="{{.ThumbnailURL}}" {{end}}controls playsinline></video>
+<p>{{.Explanation}}</p>
+</body>
+</html>
+`))
+
+var embedPlayerTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; height: 100%; background: #000; }
+video { width: 100%; height: 100%; object-fit: contain; }
+</style>
+</head>
+<body>
+<video src="{{.VideoURL}}" {{if .ThumbnailURL}}poster="{{.ThumbnailURL}}" {{end}}controls playsinline></video>
+</body>
+</html>
+`))
+
+type sharePage struct {
+	Provider     string
+	Title        string
+	Description  string
+	Explanation  string
+	PageURL      string
+	EmbedURL     string
+	OEmbedURL    string
+	VideoURL     string
+	VideoType    string
+	ThumbnailURL string
+	Duration     int
+	Width        int
+	Height       int
+}
+
+type OEmbedResponse struct {
+	Version         string `json:"version"`
+	Type            string `json:"type"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	Title           string `json:"title"`
+	HTML            string `json:"html"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+}
+
+// publicBaseURL is where share pages are served from. It must be configured:
+// the request's Host header is client-controlled, and absolute URLs built from
+// it would end up in cached unfurls and embed snippets.
+func publicBaseURL() (string, bool) {
+	base := strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/")
+	return base, base != ""
+}
+
+func shareURL(token string) string {
+	base, ok := publicBaseURL()
+	if !ok {
+		return ""
+	}
+	return base + "/s/" + token
+}
+
+// featuredMessage picks what an unfurl shows: the shared message itself, or
+// the most recent video in a shared chat.
+func featuredMessage(messages []models.Message) (models.Message, bool) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].VideoURL != "" {
+			return messages[i], true
+		}
+	}
+	return models.Message{}, false
+}
+
+func buildSharePage(token string, countView bool) (sharePage, bool) {
+	link, chat, messages, err := loadShare(token)
+	if err != nil {
+		return sharePage{}, false
+	}
+
+	message, ok := featuredMessage(messages)
+	if !ok {
+		return sharePage{}, false
+	}
+
+	if countView {
+		database.DB.Model(&link).UpdateColumn("view_count", gorm.Expr("view_count + 1"))
+	}
+
+	base, _ := publicBaseURL()
+	pageURL := base + "/s/" + token
+	return sharePage{
+		Provider:     providerName,
+		Title:        chat.Title,
+		Description:  summarize(message.Explanation, 200),
+		Explanation:  message.Explanation,
+		PageURL:      pageURL,
+		EmbedURL:     base + "/embed/" + token,
+		OEmbedURL:    base + "/oembed?format=json&url=" + url.QueryEscape(pageURL),
+		VideoURL:     message.VideoURL,
+		VideoType:    videoContentType(message.VideoURL),
+		ThumbnailURL: message.ThumbnailURL,
+		Duration:     message.Duration,
+		Width:        embedWidth,
+		Height:       embedHeight,
+	}, true
+}
+
+func summarize(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= limit {
+		return text
+	}
+	cut := strings.LastIndex(text[:limit], " ")
+	if cut <= 0 {
+		cut = limit
+	}
+	return text[:cut] + "…"
+}
+
+func videoContentType(videoURL string) string {
+	switch {
+	case strings.HasSuffix(videoURL, ".webm"):
+		return "video/webm"
+	case strings.HasSuffix(videoURL, ".mov"):
+		return "video/quicktime"
+	case strings.HasSuffix(videoURL, ".gif"):
+		return "image/gif"
+	default:
+		return "video/mp4"
+	}
+}
+
+func GetShareLanding(c *gin.Context) {
+	if _, ok := publicBaseURL(); !ok {
+		c.String(http.StatusServiceUnavailable, "share pages are not configured")
+		return
+	}
+
+	page, ok := buildSharePage(c.Param("token"), true)
+	if !ok {
+		c.String(http.StatusNotFound, "not found")
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := shareLandingTemplate.Execute(c.Writer, page); err != nil {
+		fmt.Println("error rendering share page:", err)
+	}
+}
+
+func GetEmbedPlayer(c *gin.Context) {
+	if _, ok := publicBaseURL(); !ok {
+		c.String(http.StatusServiceUnavailable, "share pages are not configured")
+		return
+	}
+
+	page, ok := buildSharePage(c.Param("token"), true)
+	if !ok {
+		c.String(http.StatusNotFound, "not found")
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Content-Security-Policy", "frame-ancestors *")
+	if err := embedPlayerTemplate.Execute(c.Writer, page); err != nil {
+		fmt.Println("error rendering embed player:", err)
+	}
+}
+
+func GetOEmbed(c *gin.Context) {
+	if format := c.DefaultQuery("format", "json"); format != "json" {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "only json is supported"})
+		return
+	}
+
+	base, ok := publicBaseURL()
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "share pages are not configured"})
+		return
+	}
+
+	target, err := url.Parse(c.Query("url"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid url"})
+		return
+	}
+
+	// Only our own share pages are embeddable; a token pasted under another
+	// host is not a link we published.
+	baseURL, err := url.Parse(base)
+	if err != nil || target.Scheme != baseURL.Scheme || !strings.EqualFold(target.Host, baseURL.Host) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	var token string
+	for _, prefix := range []string{baseURL.Path + "/s/", baseURL.Path + "/embed/"} {
+		if strings.HasPrefix(target.Path, prefix) {
+			token = strings.TrimPrefix(target.Path, prefix)
+		}
+	}
+	if token == "" || strings.Contains(token, "/") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	page, ok := buildSharePage(token, false)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	width, height := page.Width, page.Height
+	if maxWidth, err := strconv.Atoi(c.Query("maxwidth")); err == nil && maxWidth > 0 && maxWidth < width {
+		width, height = maxWidth, maxWidth*page.Height/page.Width
+	}
+	if maxHeight, err := strconv.Atoi(c.Query("maxheight")); err == nil && maxHeight > 0 && maxHeight < height {
+		width, height = maxHeight*page.Width/page.Height, maxHeight
+	}
+
+	response := OEmbedResponse{
+		Version:      "1.0",
+		Type:         "video",
+		ProviderName: providerName,
+		ProviderURL:  base,
+		Title:        page.Title,
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" allow="fullscreen" allowfullscreen></iframe>`,
+			template.HTMLEscapeString(page.EmbedURL), width, height),
+		Width:  width,
+		Height: height,
+	}
+	if page.ThumbnailURL != "" {
+		response.ThumbnailURL = page.ThumbnailURL
+		response.ThumbnailWidth = page.Width
+		response.ThumbnailHeight = page.Height
+	}
+
+	c.JSON(http.StatusOK, response)
+}
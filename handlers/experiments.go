@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/prompts"
+	"gorm.io/gorm"
+)
+
+type CreatePromptTemplateRequest struct {
+	Version          string `json:"version" binding:"required,max=64"`
+	System           string `json:"system" binding:"required"`
+	Explanation      string `json:"explanation" binding:"required"`
+	Retry            string `json:"retry"`
+	DurationSimple   string `json:"duration_simple"`
+	DurationModerate string `json:"duration_moderate"`
+	DurationComplex  string `json:"duration_complex"`
+	Notes            string `json:"notes"`
+}
+
+type ExperimentVariantRequest struct {
+	Version string `json:"version" binding:"required"`
+	Weight  int    `json:"weight" binding:"required,min=1"`
+}
+
+type CreateExperimentRequest struct {
+	Name     string                     `json:"name" binding:"required"`
+	Variants []ExperimentVariantRequest `json:"variants" binding:"required,min=2,dive"`
+}
+
+type PromptVersionReport struct {
+	PromptVersion   string         `json:"prompt_version"`
+	Generations     int            `json:"generations"`
+	Succeeded       int            `json:"succeeded"`
+	SuccessRate     float64        `json:"success_rate"`
+	Attempts        int            `json:"attempts"`
+	AverageRetries  float64        `json:"average_retries"`
+	Outcomes        map[string]int `json:"outcomes"`
+	RatingsUp       int            `json:"ratings_up"`
+	RatingsDown     int            `json:"ratings_down"`
+	ApprovalRate    float64        `json:"approval_rate"`
+	ExperimentShare float64        `json:"experiment_share,omitempty"`
+}
+
+func GetPromptTemplates(c *gin.Context) {
+	var templates []models.PromptTemplate
+	if err := database.DB.Order("created_at DESC").Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch prompt templates"})
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+func CreatePromptTemplate(c *gin.Context) {
+	var req CreatePromptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	tmpl := models.PromptTemplate{
+		Version:          req.Version,
+		System:           req.System,
+		Explanation:      req.Explanation,
+		Retry:            req.Retry,
+		DurationSimple:   req.DurationSimple,
+		DurationModerate: req.DurationModerate,
+		DurationComplex:  req.DurationComplex,
+		Notes:            req.Notes,
+	}
+	if err := prompts.Validate(tmpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing int64
+	database.DB.Model(&models.PromptTemplate{}).Where("version = ?", tmpl.Version).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "prompt version already exists"})
+		return
+	}
+
+	if err := database.DB.Create(&tmpl).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save prompt template"})
+		return
+	}
+	c.JSON(http.StatusCreated, tmpl)
+}
+
+func SetDefaultPromptTemplate(c *gin.Context) {
+	version := c.Param("version")
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var tmpl models.PromptTemplate
+		if err := tx.Where("version = ?", version).First(&tmpl).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.PromptTemplate{}).Where("is_default = ?", true).
+			Updates(map[string]interface{}{"is_default": false, "seeded_default": false}).Error; err != nil {
+			return err
+		}
+		// An admin's choice is never replaced by a newer embedded default.
+		return tx.Model(&tmpl).Updates(map[string]interface{}{"is_default": true, "seeded_default": false}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "prompt version not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update default prompt"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "default prompt updated", "version": version})
+}
+
+func GetExperiments(c *gin.Context) {
+	var experiments []models.PromptExperiment
+	if err := database.DB.Preload("Variants").Order("started_at DESC").Find(&experiments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch experiments"})
+		return
+	}
+	c.JSON(http.StatusOK, experiments)
+}
+
+// CreateExperiment starts a traffic split between prompt versions. Only one
+// experiment runs at a time, so starting one stops whatever was running.
+func CreateExperiment(c *gin.Context) {
+	var req CreateExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	seen := map[string]bool{}
+	experiment := models.PromptExperiment{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Active:    true,
+		StartedAt: time.Now(),
+	}
+	for _, v := range req.Variants {
+		if seen[v.Version] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "each version may appear only once"})
+			return
+		}
+		seen[v.Version] = true
+		experiment.Variants = append(experiment.Variants, models.PromptVariant{Version: v.Version, Weight: v.Weight})
+	}
+
+	var known int64
+	if err := database.DB.Model(&models.PromptTemplate{}).Where("version IN ?", versionList(seen)).Count(&known).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check prompt versions"})
+		return
+	}
+	if int(known) != len(seen) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown prompt version"})
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PromptExperiment{}).Where("active = ?", true).
+			Updates(map[string]interface{}{"active": false, "ended_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&experiment).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start experiment"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, experiment)
+}
+
+func StopExperiment(c *gin.Context) {
+	result := database.DB.Model(&models.PromptExperiment{}).
+		Where("id = ? AND active = ?", c.Param("id"), true).
+		Updates(map[string]interface{}{"active": false, "ended_at": time.Now()})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stop experiment"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "active experiment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "experiment stopped"})
+}
+
+func versionList(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	return out
+}
+
+// GetPromptReport compares prompt versions on pipeline success, retries and
+// user ratings, optionally limited to one experiment's traffic.
+func GetPromptReport(c *gin.Context) {
+	since := time.Now().UTC().AddDate(0, 0, -30)
+	if raw := c.Query("since"); raw != "" {
+		parsed, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be YYYY-MM-DD"})
+			return
+		}
+		since = parsed
+	}
+	experimentID := c.Query("experiment_id")
+
+	attempts := database.DB.Model(&models.GenerationAttempt{}).Where("created_at >= ?", since)
+	if experimentID != "" {
+		attempts = attempts.Where("experiment_id = ?", experimentID)
+	}
+
+	var runs []struct {
+		PromptVersion string
+		Generations   int
+		Succeeded     int
+		Attempts      int
+	}
+	err := attempts.Session(&gorm.Session{}).
+		Select(`prompt_version, COUNT(DISTINCT run_id) AS generations,
+			COUNT(DISTINCT run_id) FILTER (WHERE outcome = ?) AS succeeded,
+			COUNT(*) AS attempts`, outcomeSuccess).
+		Group("prompt_version").
+		Order("prompt_version").
+		Scan(&runs).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build report"})
+		return
+	}
+
+	var outcomes []struct {
+		PromptVersion string
+		Outcome       string
+		Total         int
+	}
+	err = attempts.Session(&gorm.Session{}).
+		Select("prompt_version, outcome, COUNT(*) AS total").
+		Group("prompt_version, outcome").
+		Scan(&outcomes).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build report"})
+		return
+	}
+
+	ratings := database.DB.Model(&models.Rating{}).Where("ratings.created_at >= ?", since)
+	if experimentID != "" {
+		ratings = ratings.Where("EXISTS (SELECT 1 FROM generation_attempts WHERE generation_attempts.message_id = ratings.message_id AND generation_attempts.experiment_id = ?)", experimentID)
+	}
+	var votes []struct {
+		PromptVersion string
+		Up            int
+		Down          int
+	}
+	err = ratings.
+		Select("ratings.prompt_version, COUNT(*) FILTER (WHERE value > 0) AS up, COUNT(*) FILTER (WHERE value < 0) AS down").
+		Group("ratings.prompt_version").
+		Scan(&votes).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build report"})
+		return
+	}
+
+	totalGenerations := 0
+	for _, r := range runs {
+		totalGenerations += r.Generations
+	}
+
+	report := make([]PromptVersionReport, 0, len(runs))
+	for _, r := range runs {
+		row := PromptVersionReport{
+			PromptVersion: r.PromptVersion,
+			Generations:   r.Generations,
+			Succeeded:     r.Succeeded,
+			Attempts:      r.Attempts,
+			Outcomes:      map[string]int{},
+		}
+		if r.Generations > 0 {
+			row.SuccessRate = float64(r.Succeeded) / float64(r.Generations)
+			row.AverageRetries = float64(r.Attempts-r.Generations) / float64(r.Generations)
+		}
+		if experimentID != "" && totalGenerations > 0 {
+			row.ExperimentShare = float64(r.Generations) / float64(totalGenerations)
+		}
+		for _, o := range outcomes {
+			if o.PromptVersion == r.PromptVersion {
+				row.Outcomes[o.Outcome] = o.Total
+			}
+		}
+		for _, v := range votes {
+			if v.PromptVersion == r.PromptVersion {
+				row.RatingsUp, row.RatingsDown = v.Up, v.Down
+				if v.Up+v.Down > 0 {
+					row.ApprovalRate = float64(v.Up) / float64(v.Up+v.Down)
+				}
+			}
+		}
+		report = append(report, row)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"since": since, "experiment_id": experimentID, "versions": report})
+}
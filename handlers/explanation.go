@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"google.golang.org/genai"
+)
+
+const maxExplanationItems = 10
+
+func stringList(description string) *genai.Schema {
+	return &genai.Schema{Type: genai.TypeArray, Description: description, Items: &genai.Schema{Type: genai.TypeString}}
+}
+
+// explanationSchema mirrors models.StructuredExplanation so the model has to
+// answer with JSON we can decode directly.
+var explanationSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"summary": {Type: genai.TypeString, Description: "One or two sentences capturing the core idea"},
+		"sections": {
+			Type:        genai.TypeArray,
+			Description: "Three to five sections of the explanation",
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"heading": {Type: genai.TypeString},
+					"body":    {Type: genai.TypeString, Description: "One paragraph"},
+				},
+				Required:         []string{"heading", "body"},
+				PropertyOrdering: []string{"heading", "body"},
+			},
+		},
+		"key_terms": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"term":       {Type: genai.TypeString},
+					"definition": {Type: genai.TypeString},
+				},
+				Required:         []string{"term", "definition"},
+				PropertyOrdering: []string{"term", "definition"},
+			},
+		},
+		"equations": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"latex":       {Type: genai.TypeString, Description: "LaTeX without $ delimiters"},
+					"description": {Type: genai.TypeString},
+				},
+				Required:         []string{"latex", "description"},
+				PropertyOrdering: []string{"latex", "description"},
+			},
+		},
+		"prerequisites":   stringList("Topics to know first"),
+		"further_reading": stringList("Topics to explore next"),
+	},
+	Required:         []string{"summary", "sections", "key_terms", "equations", "prerequisites", "further_reading"},
+	PropertyOrdering: []string{"summary", "sections", "key_terms", "equations", "prerequisites", "further_reading"},
+}
+
+// stripMath removes delimiters the model sometimes adds despite the schema.
+func stripMath(latex string) string {
+	latex = strings.TrimSpace(latex)
+	for _, pair := range [][2]string{{"$$", "$$"}, {`\[`, `\]`}, {`\(`, `\)`}, {"$", "$"}} {
+		if len(latex) >= len(pair[0])+len(pair[1]) && strings.HasPrefix(latex, pair[0]) && strings.HasSuffix(latex, pair[1]) {
+			return strings.TrimSpace(latex[len(pair[0]) : len(latex)-len(pair[1])])
+		}
+	}
+	return latex
+}
+
+func balancedBraces(latex string) bool {
+	depth := 0
+	for i := 0; i < len(latex); i++ {
+		switch latex[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+func cleanList(items []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		key := strings.ToLower(item)
+		if item == "" || seen[key] || len(out) == maxExplanationItems {
+			continue
+		}
+		seen[key] = true
+		out = append(out, item)
+	}
+	return out
+}
+
+// normalizeExplanation trims the model's answer and drops incomplete entries
+// and equations with broken LaTeX. It fails only when there is nothing
+// usable left to show.
+func normalizeExplanation(e *models.StructuredExplanation) error {
+	e.Summary = strings.TrimSpace(e.Summary)
+
+	sections := []models.ExplanationSection{}
+	for _, s := range e.Sections {
+		s.Heading, s.Body = strings.TrimSpace(s.Heading), strings.TrimSpace(s.Body)
+		if s.Body != "" && len(sections) < maxExplanationItems {
+			sections = append(sections, s)
+		}
+	}
+	e.Sections = sections
+
+	terms := []models.KeyTerm{}
+	for _, t := range e.KeyTerms {
+		t.Term, t.Definition = strings.TrimSpace(t.Term), strings.TrimSpace(t.Definition)
+		if t.Term != "" && t.Definition != "" && len(terms) < maxExplanationItems {
+			terms = append(terms, t)
+		}
+	}
+	e.KeyTerms = terms
+
+	equations := []models.Equation{}
+	for _, eq := range e.Equations {
+		eq.LaTeX, eq.Description = stripMath(eq.LaTeX), strings.TrimSpace(eq.Description)
+		if eq.LaTeX == "" || !balancedBraces(eq.LaTeX) {
+			fmt.Printf("Warning: dropping invalid equation %q from explanation\n", eq.LaTeX)
+			continue
+		}
+		if len(equations) < maxExplanationItems {
+			equations = append(equations, eq)
+		}
+	}
+	e.Equations = equations
+
+	e.Prerequisites = cleanList(e.Prerequisites)
+	e.FurtherReading = cleanList(e.FurtherReading)
+
+	if e.Summary == "" && len(e.Sections) == 0 {
+		return errors.New("explanation has no summary or sections")
+	}
+	return nil
+}
+
+// explanationText renders the summary and sections as markdown, which is what
+// the plain explanation field and exports show.
+func explanationText(e *models.StructuredExplanation) string {
+	var parts []string
+	if e.Summary != "" {
+		parts = append(parts, e.Summary)
+	}
+	for _, s := range e.Sections {
+		if s.Heading != "" {
+			parts = append(parts, "## "+s.Heading+"\n\n"+s.Body)
+		} else {
+			parts = append(parts, s.Body)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
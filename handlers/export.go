@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"gorm.io/gorm"
+)
+
+var exportClient = &http.Client{Timeout: 2 * time.Minute}
+
+var (
+	slugPattern       = regexp.MustCompile(`[^a-z0-9]+`)
+	sceneClassPattern = regexp.MustCompile(`(?m)^class\s+([A-Za-z_]\w*)\s*\(`)
+)
+
+type notebookCell struct {
+	CellType       string         `json:"cell_type"`
+	Metadata       map[string]any `json:"metadata"`
+	Source         []string       `json:"source"`
+	ExecutionCount *int           `json:"execution_count,omitempty"`
+	Outputs        []any          `json:"outputs,omitempty"`
+}
+
+type notebook struct {
+	Cells         []notebookCell `json:"cells"`
+	Metadata      map[string]any `json:"metadata"`
+	NBFormat      int            `json:"nbformat"`
+	NBFormatMinor int            `json:"nbformat_minor"`
+}
+
+func exportSlug(title string) string {
+	slug := strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if slug == "" {
+		return "chat"
+	}
+	if len(slug) > 60 {
+		slug = strings.TrimRight(slug[:60], "-")
+	}
+	return slug
+}
+
+func notebookLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func markdownCell(text string) notebookCell {
+	return notebookCell{CellType: "markdown", Metadata: map[string]any{}, Source: notebookLines(text)}
+}
+
+func codeCell(text string) notebookCell {
+	return notebookCell{CellType: "code", Metadata: map[string]any{}, Source: notebookLines(text), Outputs: []any{}}
+}
+
+func buildTranscript(chat models.Chat) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", chat.Title)
+	fmt.Fprintf(&b, "_Exported %s_\n\n", time.Now().UTC().Format(time.RFC1123))
+	for _, msg := range chat.Messages {
+		stamp := msg.CreatedAt.UTC().Format("2006-01-02 15:04")
+		if msg.Role == "user" {
+			fmt.Fprintf(&b, "## You · %s\n\n%s\n\n", stamp, msg.Content)
+			continue
+		}
+		fmt.Fprintf(&b, "## Assistant · %s\n\n", stamp)
+		if msg.Explanation != "" {
+			fmt.Fprintf(&b, "%s\n\n", msg.Explanation)
+		}
+		if msg.VideoURL != "" {
+			fmt.Fprintf(&b, "[Video](%s)\n\n", msg.VideoURL)
+		}
+	}
+	return b.String()
+}
+
+func buildNotebook(chat models.Chat) notebook {
+	cells := []notebookCell{
+		markdownCell(fmt.Sprintf("# %s\n\nRun the setup cell once, then each scene cell to render it inline.", chat.Title)),
+		codeCell("from manim import *\n\nconfig.media_embed = True"),
+	}
+
+	prompt := ""
+	for _, msg := range chat.Messages {
+		if msg.Role == "user" {
+			prompt = msg.Content
+			continue
+		}
+		if msg.Code == "" {
+			continue
+		}
+		if prompt != "" {
+			cells = append(cells, markdownCell("## "+prompt))
+		}
+		scene := "Scene"
+		if match := sceneClassPattern.FindStringSubmatch(msg.Code); match != nil {
+			scene = match[1]
+		}
+		cells = append(cells, codeCell("%%manim -qm -v WARNING "+scene+"\n\n"+msg.Code))
+	}
+
+	return notebook{
+		Cells: cells,
+		Metadata: map[string]any{
+			"kernelspec":    map[string]string{"name": "python3", "display_name": "Python 3", "language": "python"},
+			"language_info": map[string]string{"name": "python"},
+		},
+		NBFormat:      4,
+		NBFormatMinor: 5,
+	}
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func copyVideo(zw *zip.Writer, name, videoURL string) error {
+	resp, err := exportClient.Get(videoURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	// Videos are already compressed, so store them as-is.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func ExportChat(c *gin.Context) {
+	chatID := c.Param("id")
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Preload("Messages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC")
+	})
+	chat, ok := findAccessibleChat(c, query, chatID, user, RoleViewer)
+	if !ok {
+		return
+	}
+	chat.Messages = conversationPath(chat.Messages)
+
+	nb, err := json.MarshalIndent(buildNotebook(chat), "", " ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build notebook"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, exportSlug(chat.Title)))
+	c.Status(http.StatusOK)
+
+	// The response is already committed once streaming starts, so failures
+	// past this point can only be logged.
+	zw := zip.NewWriter(c.Writer)
+	defer zw.Close()
+
+	if err := writeZipFile(zw, "transcript.md", []byte(buildTranscript(chat))); err != nil {
+		fmt.Printf("Warning: export of chat %s failed: %v\n", chat.ID, err)
+		return
+	}
+	if err := writeZipFile(zw, "scenes.ipynb", nb); err != nil {
+		fmt.Printf("Warning: export of chat %s failed: %v\n", chat.ID, err)
+		return
+	}
+
+	n := 0
+	for _, msg := range chat.Messages {
+		if msg.Role != "assistant" {
+			continue
+		}
+		n++
+		dir := fmt.Sprintf("message-%02d/", n)
+
+		if msg.Code != "" {
+			if err := writeZipFile(zw, dir+"scene.py", []byte(msg.Code)); err != nil {
+				fmt.Printf("Warning: export of chat %s failed: %v\n", chat.ID, err)
+				return
+			}
+		}
+		if msg.Explanation != "" {
+			if err := writeZipFile(zw, dir+"explanation.md", []byte(msg.Explanation+"\n")); err != nil {
+				fmt.Printf("Warning: export of chat %s failed: %v\n", chat.ID, err)
+				return
+			}
+		}
+		if msg.VideoURL != "" {
+			ext := path.Ext(msg.VideoURL)
+			if ext == "" {
+				ext = ".mp4"
+			}
+			if err := copyVideo(zw, dir+"video"+ext, msg.VideoURL); err != nil {
+				fmt.Printf("Warning: failed to add video for message %s to export: %v\n", msg.ID, err)
+			}
+		}
+	}
+}
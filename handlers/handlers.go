@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,170 +12,112 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/jobs"
+	"github.com/tabishnaqvi1311/manimbot-backend/middleware"
 	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/prompts"
 	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/genai"
+	"gorm.io/gorm"
 )
 
-const SystemPrompt = `
-You are an expert in creating educational animations with Manim in the style of 3Blue1Brown. 
-Generate Python code using the Manim library to visualize and explain the concept with smooth, elegant animations.
-
-CRITICAL REQUIREMENTS:
-- The class MUST be named "Scene" exactly
-- Use "from manim import *" for imports
-- **ALL COORDINATES MUST BE 3D**: Manim requires 3-dimensional coordinates [x, y, z]
-  * For 2D visualizations, set z=0: np.array([x, y, 0])
-  * Convert 2D points to 3D: np.append(point_2d, 0) or [x, y, 0]
-  * Use Manim vectors: RIGHT*x + UP*y (automatically 3D)
-- MINIMUM 60 SECONDS duration - use self.wait() strategically to ensure this
-- Style animations like 3Blue1Brown: smooth, thoughtful, mathematically elegant
-- Use run_time parameters (typically 1-2 seconds) for smoother animations
-- Add rate_func=smooth for fluid motion (e.g., rate_func=rate_functions.smooth)
-
-3Blue1Brown Animation Style:
-- Smooth transformations with appropriate run_time (1-3 seconds per animation)
-- Use Transform, ReplacementTransform, and FadeTransform for elegant transitions
-- Include self.wait(1-3) after important visuals for viewer comprehension
-- Use color gradients and visual hierarchy (BLUE, YELLOW, GREEN for emphasis)
-- Build complexity gradually - introduce one element at a time
-- Use Tex() for mathematical expressions with proper LaTeX formatting
-- Animate text and equations appearing with Write() or FadeIn() over 1-2 seconds
-
-COORDINATE HANDLING - CRITICAL:
-When working with data points, scatter plots, or clustering:
-  # Generate 2D data
-  points_2d = np.random.randn(20, 2)
-  
-  # Convert to 3D for Manim (REQUIRED)
-  points_3d = np.array([[x, y, 0] for x, y in points_2d])
-  # OR
-  points_3d = [np.append(point, 0) for point in points_2d]
-  
-  # Create dots with 3D coordinates
-  dots = VGroup(*[Dot(point, radius=0.1, color=BLUE) for point in points_3d])
-
-For positioning objects:
-  obj.move_to(np.array([2, 1, 0]))  # Always 3D
-  obj.move_to(RIGHT*2 + UP*1)       # Manim's vector notation (automatically 3D)
-
-Animation Smoothness Tips:
-- Always specify run_time for self.play() (minimum 0.5s, typically 1-2s)
-- Use self.wait(1-2) between major concepts
-- Avoid choppy animations - use Transform instead of removing/adding
-- Example: self.play(Transform(obj1, obj2), run_time=2, rate_func=smooth)
-
-Duration Structure (MINIMUM 60 seconds total):
-- Introduction with title: 10-15s
-- Core explanation with visuals: 25-40s  
-- Examples or variations: 20-40s
-- Summary or key insight: 10-15s
-- Add self.wait(2-3) at the end
-
-Example structure:
-from manim import *
-import numpy as np
-
-class Scene(Scene):
-    def construct(self):
-        # Title (10s)
-        title = Text("Concept Name", font_size=48)
-        self.play(Write(title), run_time=2)
-        self.wait(2)
-        self.play(FadeOut(title), run_time=1)
-        
-        # For data visualization (convert 2D to 3D!)
-        data_2d = np.random.randn(10, 2)
-        data_3d = np.array([[x, y, 0] for x, y in data_2d])
-        dots = VGroup(*[Dot(point, radius=0.1, color=BLUE) for point in data_3d])
-        self.play(Create(dots), run_time=2)
-        self.wait(2)
-        
-        # More animations...
-        self.wait(2)
-
-The code must:
-- Convert ALL 2D coordinates to 3D format [x, y, 0]
-- Be MINIMUM 60 seconds (use self.wait() to ensure this)
-- Use run_time parameters on ALL self.play() calls
-- Create smooth, professional animations like 3Blue1Brown
-- Work with Manim Community Edition
-- Be self-contained and runnable
-`
-
-const ExplanationPrompt = `
-Provide a clear, comprehensive explanation of this concept in 3-5 paragraphs. 
-Focus on the key principles, practical understanding, and real-world applications.
-Make it accessible but informative, suitable for learners at various levels.
-`
+const generationModel = "gemini-2.0-flash"
 
 type GenerateRequest struct {
-	Prompt string `json:"prompt" binding:"required"`
-	ChatID string `json:"chat_id"`
+	Prompt      string `json:"prompt" binding:"required"`
+	ChatID      string `json:"chat_id"`
+	Quality     string `json:"quality" binding:"omitempty,oneof=low medium high"`
+	Format      string `json:"format" binding:"omitempty,oneof=mp4 webm gif mov"`
+	Style       string `json:"style" binding:"omitempty,oneof=3b1b whiteboard chalkboard high-contrast brand"`
+	Complexity  string `json:"complexity" binding:"omitempty,oneof=simple moderate complex"`
+	MinDuration int    `json:"min_duration" binding:"omitempty,min=5,max=1800"`
+	MaxDuration int    `json:"max_duration" binding:"omitempty,min=5,max=1800"`
+	ReuseCached bool   `json:"reuse_cached"`
+	Stream      bool   `json:"stream"`
+	WorkspaceID string `json:"workspace_id"`
 }
 
 type ChatResponse struct {
-	ChatID      string    `json:"chat_id"`
-	MessageID   string    `json:"message_id"`
-	VideoURL    string    `json:"video_url"`
-	Explanation string    `json:"explanation"`
-	Duration    int       `json:"duration"`
-	CreatedAt   time.Time `json:"created_at"`
+	ChatID          string                        `json:"chat_id"`
+	Title           string                        `json:"title,omitempty"`
+	MessageID       string                        `json:"message_id"`
+	VideoURL        string                        `json:"video_url"`
+	ThumbnailURL    string                        `json:"thumbnail_url,omitempty"`
+	Explanation     string                        `json:"explanation"`
+	ExplanationData *models.StructuredExplanation `json:"explanation_data,omitempty"`
+	Duration        int                           `json:"duration"`
+	ReusedFrom      string                        `json:"reused_from,omitempty"`
+	ParentID        string                        `json:"parent_id,omitempty"`
+	Target          *durationTarget               `json:"target,omitempty"`
+	CreatedAt       time.Time                     `json:"created_at"`
 }
 
 type ChatHistoryResponse struct {
+	ID                 string    `json:"id"`
+	Title              string    `json:"title"`
+	Pinned             bool      `json:"pinned"`
+	Archived           bool      `json:"archived"`
+	TitleHighlight     string    `json:"title_highlight,omitempty"`
+	Snippet            string    `json:"snippet,omitempty"`
+	MatchingMessageIDs []string  `json:"matching_message_ids,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+type TrashedChatResponse struct {
 	ID        string    `json:"id"`
 	Title     string    `json:"title"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+type UpdateChatRequest struct {
+	Title       *string `json:"title"`
+	Pinned      *bool   `json:"pinned"`
+	Archived    *bool   `json:"archived"`
+	WorkspaceID *string `json:"workspace_id"`
 }
 
 type ChatDetailResponse struct {
-	ID        string            `json:"id"`
-	Title     string            `json:"title"`
-	Messages  []MessageResponse `json:"messages"`
-	CreatedAt time.Time         `json:"created_at"`
+	ID          string            `json:"id"`
+	Title       string            `json:"title"`
+	WorkspaceID string            `json:"workspace_id,omitempty"`
+	Messages    []MessageResponse `json:"messages"`
+	Branches    []BranchResponse  `json:"branches,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
 }
 
 type MessageResponse struct {
-	ID          string    `json:"id"`
-	Role        string    `json:"role"`
-	Content     string    `json:"content"`
-	VideoURL    string    `json:"video_url,omitempty"`
-	Explanation string    `json:"explanation,omitempty"`
-	Duration    int       `json:"duration,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID              string                        `json:"id"`
+	Role            string                        `json:"role"`
+	Content         string                        `json:"content"`
+	VideoURL        string                        `json:"video_url,omitempty"`
+	ThumbnailURL    string                        `json:"thumbnail_url,omitempty"`
+	Explanation     string                        `json:"explanation,omitempty"`
+	ExplanationData *models.StructuredExplanation `json:"explanation_data,omitempty"`
+	Duration        int                           `json:"duration,omitempty"`
+	Code            string                        `json:"code,omitempty"`
+	Revision        int                           `json:"revision,omitempty"`
+	Style           string                        `json:"style,omitempty"`
+	Complexity      string                        `json:"complexity,omitempty"`
+	ReusedFromID    string                        `json:"reused_from_id,omitempty"`
+	ParentID        string                        `json:"parent_id,omitempty"`
+	BranchIndex     int                           `json:"branch_index,omitempty"`
+	BranchCount     int                           `json:"branch_count,omitempty"`
+	CreatedAt       time.Time                     `json:"created_at"`
 }
 
-func assessComplexity(prompt string) string {
-	prompt = strings.ToLower(prompt)
-	complexKeywords := []string{"quantum", "relativity", "calculus", "theorem", "theory", "proof", "derive", "integrate", "differential"}
-	moderateKeywords := []string{"explain", "how does", "why", "concept", "principle"}
-
-	for _, keyword := range complexKeywords {
-		if strings.Contains(prompt, keyword) {
-			return "complex"
-		}
-	}
-
-	for _, keyword := range moderateKeywords {
-		if strings.Contains(prompt, keyword) {
-			return "moderate"
-		}
-	}
-
-	return "simple"
+var skipWords = map[string]bool{
+	"can": true, "you": true, "help": true, "me": true, "explain": true,
+	"show": true, "demonstrate": true, "visualize": true, "create": true,
+	"make": true, "generate": true, "the": true, "a": true, "an": true,
 }
 
 func generateTitle(prompt string) string {
 	words := strings.Fields(prompt)
 
-	skipWords := map[string]bool{
-		"can": true, "you": true, "help": true, "me": true, "explain": true,
-		"show": true, "demonstrate": true, "visualize": true, "create": true,
-		"make": true, "generate": true, "the": true, "a": true, "an": true,
-	}
-
 	var titleWords []string
 	for _, word := range words {
 		if !skipWords[strings.ToLower(word)] {
@@ -196,68 +139,79 @@ func generateTitle(prompt string) string {
 	return title
 }
 
-func generateManim(ctx context.Context, prompt string, complexity string, previousError string) (string, error) {
+func generateManim(ctx context.Context, set prompts.Set, prompt string, target durationTarget, styleGuide string, previousError string) (string, tokenUsage, error) {
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	if apiKey == "" {
-		return "", fmt.Errorf("env not set")
+		return "", tokenUsage{}, fmt.Errorf("env not set")
 	}
 
-	durationGuide := ""
-	switch complexity {
-	case "simple":
-		durationGuide = "Target: 60-120 seconds. Create smooth animations with proper run_time. Show one clear example with elegant transformations."
-	case "moderate":
-		durationGuide = "Target: 120-240 seconds. Use multiple examples with smooth transitions. Include intermediate steps with self.wait() for comprehension."
-	case "complex":
-		durationGuide = "Target: 240-600 seconds. Break into clear segments. Show step-by-step derivations with patient pacing. Multiple examples with detailed explanations."
-	}
-
-	fullPrompt := SystemPrompt + "\n" + durationGuide + "\n\nUser request: " + prompt
-
-	if previousError != "" {
-		fullPrompt += fmt.Sprintf("\n\nIMPORTANT: Previous attempt failed with error:\n%s\n\nPlease fix this error. Common issues:\n- 2D coordinates not converted to 3D (use np.array([x, y, 0]) or np.append(point, 0))\n- Missing imports (numpy as np)\n- Incorrect Dot() positioning (must use 3D coordinates)\n\nEnsure ALL coordinates are 3D format.", previousError)
+	fullPrompt, err := set.Code(prompts.Vars{
+		Prompt:        prompt,
+		Complexity:    target.Complexity,
+		MinDuration:   target.Min,
+		MaxDuration:   target.Max,
+		StyleGuide:    styleGuide,
+		PreviousError: previousError,
+	})
+	if err != nil {
+		return "", tokenUsage{}, err
 	}
 
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey})
 	if err != nil {
-		return "", err
+		return "", tokenUsage{}, err
 	}
 
-	result, err := client.Models.GenerateContent(ctx, "gemini-2.0-flash", genai.Text(fullPrompt), nil)
+	result, err := client.Models.GenerateContent(ctx, generationModel, genai.Text(fullPrompt), nil)
 	if err != nil {
-		return "", err
+		return "", tokenUsage{}, err
 	}
 
+	usage := usageFromResponse(result)
 	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil || len(result.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("unexpected response format")
+		return "", usage, fmt.Errorf("unexpected response format")
 	}
 
-	return result.Candidates[0].Content.Parts[0].Text, nil
+	return result.Candidates[0].Content.Parts[0].Text, usage, nil
 }
 
-func generateExplanation(ctx context.Context, prompt string) (string, error) {
+func generateExplanation(ctx context.Context, set prompts.Set, prompt string) (*models.StructuredExplanation, tokenUsage, error) {
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	if apiKey == "" {
-		return "", fmt.Errorf("env not set")
+		return nil, tokenUsage{}, fmt.Errorf("env not set")
 	}
 
-	fullPrompt := ExplanationPrompt + "\n\nTopic: " + prompt
+	fullPrompt, err := set.Explanation(prompts.Vars{Prompt: prompt})
+	if err != nil {
+		return nil, tokenUsage{}, err
+	}
 
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey})
 	if err != nil {
-		return "", err
+		return nil, tokenUsage{}, err
 	}
 
-	result, err := client.Models.GenerateContent(ctx, "gemini-2.0-flash", genai.Text(fullPrompt), nil)
+	result, err := client.Models.GenerateContent(ctx, generationModel, genai.Text(fullPrompt), &genai.GenerateContentConfig{
+		ResponseMIMEType: "application/json",
+		ResponseSchema:   explanationSchema,
+	})
 	if err != nil {
-		return "", err
+		return nil, tokenUsage{}, err
 	}
 
+	usage := usageFromResponse(result)
 	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil || len(result.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("unexpected response format")
+		return nil, usage, fmt.Errorf("unexpected response format")
 	}
 
-	return result.Candidates[0].Content.Parts[0].Text, nil
+	var explanation models.StructuredExplanation
+	if err := json.Unmarshal([]byte(result.Candidates[0].Content.Parts[0].Text), &explanation); err != nil {
+		return nil, usage, fmt.Errorf("invalid explanation json: %w", err)
+	}
+	if err := normalizeExplanation(&explanation); err != nil {
+		return nil, usage, err
+	}
+	return &explanation, usage, nil
 }
 
 func isCoordinateError(err error) bool {
@@ -268,43 +222,83 @@ func isCoordinateError(err error) bool {
 		strings.Contains(errStr, "operands could not be broadcast")
 }
 
+// resolveChat returns the chat a new message should go into: an existing chat
+// the caller can edit, or a freshly created one (optionally in a workspace).
+func resolveChat(c *gin.Context, user models.User, chatID, workspaceID, title string) (models.Chat, bool) {
+	if chatID != "" {
+		return findAccessibleChat(c, database.DB, chatID, user, RoleEditor)
+	}
+
+	chat := models.Chat{
+		ID:     uuid.New().String(),
+		UserID: user.ID,
+		Title:  title,
+	}
+	if workspaceID != "" {
+		if !requireWorkspaceRole(c, workspaceID, user, RoleEditor) {
+			return chat, false
+		}
+		chat.WorkspaceID = &workspaceID
+	}
+	if err := database.DB.Create(&chat).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create chat"})
+		return chat, false
+	}
+	return chat, true
+}
+
+// storeRender uploads a finished render and its thumbnail, returning their
+// public URLs. Cached renders are already stored and pass straight through.
+func storeRender(render utils.RenderResult, usage *models.UsageRecord) (string, string, error) {
+	thumbnailURL := render.ThumbnailURL
+	if thumbnailURL == "" && !utils.IsStoredURL(render.Path) {
+		var thumbnailBytes int64
+		var err error
+		thumbnailURL, thumbnailBytes, err = utils.UploadThumbnail(render.Path, render.Duration)
+		if err != nil {
+			fmt.Println("warning: could not create thumbnail:", err)
+			thumbnailURL = ""
+		}
+		usage.StoredBytes += thumbnailBytes
+	}
+
+	videoURL, storedBytes, err := utils.UploadToS3(render.Path)
+	if err != nil {
+		utils.RemoveLocalRender(render.Path)
+	}
+	usage.StoredBytes += storedBytes
+	return videoURL, thumbnailURL, err
+}
+
 func HandleGenerate(c *gin.Context) {
 	var req GenerateRequest
-	startTime := time.Now()
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
 
-	clerkUserID := c.GetHeader("X-User-ID")
-	if clerkUserID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	override := complexityOverride{Complexity: req.Complexity, MinDuration: req.MinDuration, MaxDuration: req.MaxDuration}
+	if !override.valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_duration must not exceed max_duration"})
 		return
 	}
 
-	var user models.User
-	if err := database.DB.Where("clerk_id = ?", clerkUserID).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+	user, ok := authenticatedUser(c)
+	if !ok {
 		return
 	}
 
-	var chat models.Chat
-	if req.ChatID != "" {
-		if err := database.DB.Where("id = ? AND user_id = ?", req.ChatID, user.ID).First(&chat).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "chat not found"})
-			return
-		}
-	} else {
-		chat = models.Chat{
-			ID:     uuid.New().String(),
-			UserID: user.ID,
-			Title:  generateTitle(req.Prompt),
-		}
-		if err := database.DB.Create(&chat).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create chat"})
-			return
-		}
+	renderOpts := utils.RenderOptions{Quality: req.Quality, Format: req.Format, Style: resolveStyle(req.Style, user)}.WithDefaults()
+	reservation, ok := enforceQuota(c, user, renderOpts)
+	if !ok {
+		return
+	}
+
+	chat, ok := resolveChat(c, user, req.ChatID, req.WorkspaceID, generateTitle(req.Prompt))
+	if !ok {
+		releaseQuota(reservation)
+		return
 	}
 
 	userMessage := models.Message{
@@ -314,224 +308,405 @@ func HandleGenerate(c *gin.Context) {
 		Content: req.Prompt,
 	}
 	if err := database.DB.Create(&userMessage).Error; err != nil {
+		releaseQuota(reservation)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save message"})
 		return
 	}
 
-	complexity := assessComplexity(req.Prompt)
-
-	maxRetries := 2
-	var lastError string
-	var video string
-	var actualDuration int
-	var s3Url string
-	var explanation string
-
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		if attempt > 0 {
-			fmt.Printf("Retry attempt %d/%d due to coordinate error\n", attempt, maxRetries)
-		}
-
-		content, err := generateManim(c.Request.Context(), req.Prompt, complexity, lastError)
-		if err != nil {
-			fmt.Println("error generating manim code:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate animation code"})
+	promptKey := promptCacheKey(req.Prompt, renderOpts)
+	respond := newGenerateResponder(c, req.Stream)
+	respond.event("started", gin.H{"chat_id": chat.ID, "message_id": userMessage.ID})
+
+	if req.ReuseCached {
+		if source, ok := findReusableMessage(promptKey); ok && override.accepts(source.Duration) {
+			reusedMessage := models.Message{
+				ID:              uuid.New().String(),
+				ChatID:          chat.ID,
+				Role:            "assistant",
+				Content:         req.Prompt,
+				VideoURL:        source.VideoURL,
+				ThumbnailURL:    source.ThumbnailURL,
+				Explanation:     source.Explanation,
+				ExplanationData: source.ExplanationData,
+				Duration:        source.Duration,
+				Code:            source.Code,
+				ParentID:        userMessage.ID,
+				Selected:        true,
+				Model:           source.Model,
+				PromptVersion:   source.PromptVersion,
+				Style:           source.Style,
+				Complexity:      source.Complexity,
+				PromptKey:       promptKey,
+				ReusedFromID:    source.ID,
+			}
+			if err := database.DB.Create(&reusedMessage).Error; err != nil {
+				releaseQuota(reservation)
+				respond.fail(http.StatusInternalServerError, "failed to save response")
+				return
+			}
+			utils.TrackObject(reusedMessage.ID, reusedMessage.VideoURL, 0)
+			utils.TrackObject(reusedMessage.ID, reusedMessage.ThumbnailURL, 0)
+			releaseQuota(reservation)
+
+			fmt.Printf("reused generation %s for prompt key %q\n", source.ID, promptKey)
+			respond.done(ChatResponse{
+				ChatID:          chat.ID,
+				MessageID:       reusedMessage.ID,
+				VideoURL:        reusedMessage.VideoURL,
+				ThumbnailURL:    reusedMessage.ThumbnailURL,
+				Explanation:     reusedMessage.Explanation,
+				ExplanationData: reusedMessage.ExplanationData,
+				Duration:        reusedMessage.Duration,
+				ReusedFrom:      source.ID,
+				ParentID:        userMessage.ID,
+				CreatedAt:       reusedMessage.CreatedAt,
+			})
 			return
 		}
-		fmt.Printf("generated manim code in [%s]\n", time.Since(startTime))
+	}
+
+	usage := models.UsageRecord{UserID: user.ID}
+	defer recordUsage(&usage)
 
-		if attempt == 0 {
-			explanation, err = generateExplanation(c.Request.Context(), req.Prompt)
+	// The title, explanation and scene are generated side by side. Only a
+	// failed scene cancels the others; the title and explanation fall back.
+	g, ctx := errgroup.WithContext(c.Request.Context())
+
+	var title string
+	var titleUsage tokenUsage
+	if req.ChatID == "" {
+		g.Go(func() error {
+			generated, generatedUsage, err := generateChatTitle(ctx, req.Prompt)
+			titleUsage = generatedUsage
 			if err != nil {
-				fmt.Println("error generating explanation:", err)
-				explanation = "Explanation unavailable"
+				if ctx.Err() == nil {
+					fmt.Println("error generating title:", err)
+				}
+				return nil
 			}
+			title = generated
+			respond.event("title", gin.H{"chat_id": chat.ID, "title": title})
+			return nil
+		})
+	}
+
+	var result generation
+	g.Go(func() error {
+		var err error
+		result, err = generateAnimation(ctx, req.Prompt, renderOpts, prompts.Resolve(user.ID), override, &usage, func(text string, data *models.StructuredExplanation) {
+			respond.event("explanation", gin.H{"explanation": text, "explanation_data": data})
+		})
+		return err
+	})
+
+	err := g.Wait()
+	titleUsage.addTo(&usage)
+	if title != "" {
+		if dbErr := database.DB.Model(&chat).Update("title", title).Error; dbErr != nil {
+			fmt.Printf("Warning: failed to update title of chat %s: %v\n", chat.ID, dbErr)
+			title = ""
 		}
+	}
+	if err != nil {
+		respond.fail(http.StatusInternalServerError, err.Error())
+		return
+	}
 
-		startTime = time.Now()
-		code := utils.ExtractCode(content)
-		if code == "" {
-			fmt.Println("error: could not extract code from response")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to extract animation code"})
+	assistantMessage, err := saveGeneration(chat.ID, userMessage.ID, req.Prompt, promptKey, result, &usage)
+	if err != nil {
+		respond.fail(http.StatusInternalServerError, "failed to save response")
+		return
+	}
+
+	respond.done(ChatResponse{
+		ChatID:          chat.ID,
+		Title:           title,
+		MessageID:       assistantMessage.ID,
+		VideoURL:        result.VideoURL,
+		ThumbnailURL:    result.ThumbnailURL,
+		Explanation:     result.Explanation,
+		ExplanationData: result.ExplanationData,
+		Duration:        result.Duration,
+		ParentID:        userMessage.ID,
+		Target:          &result.Target,
+		CreatedAt:       assistantMessage.CreatedAt,
+	})
+}
+
+func GetChatHistory(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Where("chats.user_id = ?", user.ID)
+	if workspaceID := c.Query("workspace_id"); workspaceID != "" {
+		if !requireWorkspaceRole(c, workspaceID, user, RoleViewer) {
 			return
 		}
-		fmt.Printf("extracted code in [%s]\n", time.Since(startTime))
+		query = database.DB.Where("chats.workspace_id = ?", workspaceID)
+	}
+	if c.Query("include_archived") != "true" {
+		query = query.Where("chats.archived = ?", false)
+	}
 
-		startTime = time.Now()
-		video, actualDuration, err = utils.RunCode(code)
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw)
 		if err != nil {
-			fmt.Println("error running code:", err)
-			dir, _ := os.Getwd()
-			os.RemoveAll(dir + "/static")
-
-			if isCoordinateError(err) && attempt < maxRetries {
-				lastError = err.Error()
-				fmt.Printf("Detected coordinate error, retrying with error context...\n")
-				continue
-			}
-
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("animation generation failed: %v", err)})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
 			return
 		}
+		query = applyCursor(query, cursor)
+	}
 
-		if actualDuration < 60 {
-			fmt.Printf("Warning: Video duration (%ds) is below minimum.\n", actualDuration)
-			dir, _ := os.Getwd()
-			os.RemoveAll(dir + "/static")
+	q := strings.TrimSpace(c.Query("q"))
+	if q != "" {
+		query = applySearch(query, q)
+	}
 
-			if attempt < maxRetries {
-				lastError = fmt.Sprintf("Video duration was only %d seconds, need at least 60 seconds", actualDuration)
-				continue
-			}
+	limit := pageSize(c.Query("limit"))
 
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("animation too short (%ds). Animations must be at least 60 seconds", actualDuration)})
-			return
-		}
+	var chats []models.Chat
+	if err := query.Order("chats.pinned DESC, chats.updated_at DESC, chats.id DESC").Limit(limit + 1).Find(&chats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch chats"})
+		return
+	}
 
-		fmt.Printf("ran code and measured duration (%ds) in [%s]\n", actualDuration, time.Since(startTime))
+	if len(chats) > limit {
+		chats = chats[:limit]
+		c.Header("X-Next-Cursor", encodeCursor(chats[limit-1]))
+	}
 
-		startTime = time.Now()
-		s3Url, err = utils.UploadToS3(video)
-		if err != nil {
-			fmt.Println("error uploading to s3:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload video"})
-			return
+	response := make([]ChatHistoryResponse, len(chats))
+	for i, chat := range chats {
+		response[i] = ChatHistoryResponse{
+			ID:        chat.ID,
+			Title:     chat.Title,
+			Pinned:    chat.Pinned,
+			Archived:  chat.Archived,
+			CreatedAt: chat.CreatedAt,
+			UpdatedAt: chat.UpdatedAt,
 		}
-		fmt.Printf("uploaded to s3 in [%s]\n", time.Since(startTime))
+	}
 
-		break
+	if q != "" {
+		if err := attachSearchMatches(response, q); err != nil {
+			fmt.Println("error fetching search matches:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search chats"})
+			return
+		}
 	}
 
-	assistantMessage := models.Message{
-		ID:          uuid.New().String(),
-		ChatID:      chat.ID,
-		Role:        "assistant",
-		Content:     req.Prompt,
-		VideoURL:    s3Url,
-		Explanation: explanation,
-		Duration:    actualDuration,
+	c.JSON(http.StatusOK, response)
+}
+
+func GetChatDetail(c *gin.Context) {
+	chatID := c.Param("id")
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
 	}
-	if err := database.DB.Create(&assistantMessage).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save response"})
+
+	query := database.DB.Preload("Messages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC")
+	})
+	chat, ok := findAccessibleChat(c, query, chatID, user, RoleViewer)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, ChatResponse{
-		ChatID:      chat.ID,
-		MessageID:   assistantMessage.ID,
-		VideoURL:    s3Url,
-		Explanation: explanation,
-		Duration:    actualDuration,
-		CreatedAt:   assistantMessage.CreatedAt,
+	messages, branches := buildBranches(chat.Messages)
+
+	var workspaceID string
+	if chat.WorkspaceID != nil {
+		workspaceID = *chat.WorkspaceID
+	}
+
+	c.JSON(http.StatusOK, ChatDetailResponse{
+		ID:          chat.ID,
+		Title:       chat.Title,
+		WorkspaceID: workspaceID,
+		Messages:    messages,
+		Branches:    branches,
+		CreatedAt:   chat.CreatedAt,
 	})
 }
 
-func GetChatHistory(c *gin.Context) {
-	clerkUserID := c.GetHeader("X-User-ID")
-	if clerkUserID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+func DeleteChat(c *gin.Context) {
+	chatID := c.Param("id")
+	user, ok := authenticatedUser(c)
+	if !ok {
 		return
 	}
 
-	var user models.User
-	if err := database.DB.Where("clerk_id = ?", clerkUserID).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+	chat, ok := findAccessibleChat(c, database.DB, chatID, user, RoleEditor)
+	if !ok {
 		return
 	}
 
+	if err := database.DB.Delete(&chat).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "chat deleted successfully"})
+}
+
+func GetTrash(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	// Workspace chats can be deleted by any editor, so the workspace's trash is
+	// shared the same way.
+	query := database.DB.Unscoped().Where("user_id = ?", user.ID)
+	if workspaceID := c.Query("workspace_id"); workspaceID != "" {
+		if !requireWorkspaceRole(c, workspaceID, user, RoleEditor) {
+			return
+		}
+		query = database.DB.Unscoped().Where("workspace_id = ?", workspaceID)
+	}
+
 	var chats []models.Chat
-	if err := database.DB.Where("user_id = ?", user.ID).Order("updated_at DESC").Find(&chats).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch chats"})
+	if err := query.Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&chats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch trash"})
 		return
 	}
 
-	response := make([]ChatHistoryResponse, len(chats))
+	retention := jobs.TrashRetention()
+	response := make([]TrashedChatResponse, len(chats))
 	for i, chat := range chats {
-		response[i] = ChatHistoryResponse{
+		response[i] = TrashedChatResponse{
 			ID:        chat.ID,
 			Title:     chat.Title,
 			CreatedAt: chat.CreatedAt,
-			UpdatedAt: chat.UpdatedAt,
+			DeletedAt: chat.DeletedAt.Time,
+			PurgeAt:   chat.DeletedAt.Time.Add(retention),
 		}
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-func GetChatDetail(c *gin.Context) {
+func RestoreChat(c *gin.Context) {
 	chatID := c.Param("id")
-	clerkUserID := c.GetHeader("X-User-ID")
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
 
-	if clerkUserID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	chat, ok := findAccessibleChat(c, database.DB.Unscoped().Where("deleted_at IS NOT NULL"), chatID, user, RoleEditor)
+	if !ok {
 		return
 	}
 
-	var user models.User
-	if err := database.DB.Where("clerk_id = ?", clerkUserID).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+	if err := database.DB.Unscoped().Model(&chat).Update("deleted_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "chat restored successfully"})
+}
+
+func UpdateChat(c *gin.Context) {
+	chatID := c.Param("id")
+
+	var req UpdateChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
 
-	var chat models.Chat
-	if err := database.DB.Preload("Messages").Where("id = ? AND user_id = ?", chatID, user.ID).First(&chat).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "chat not found"})
+	user, ok := authenticatedUser(c)
+	if !ok {
 		return
 	}
 
-	messages := make([]MessageResponse, len(chat.Messages))
-	for i, msg := range chat.Messages {
-		messages[i] = MessageResponse{
-			ID:          msg.ID,
-			Role:        msg.Role,
-			Content:     msg.Content,
-			VideoURL:    msg.VideoURL,
-			Explanation: msg.Explanation,
-			Duration:    msg.Duration,
-			CreatedAt:   msg.CreatedAt,
+	updates := map[string]interface{}{}
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "title cannot be empty"})
+			return
 		}
+		updates["title"] = truncateTitle(title)
+	}
+	if req.Pinned != nil {
+		updates["pinned"] = *req.Pinned
+	}
+	if req.Archived != nil {
+		updates["archived"] = *req.Archived
+	}
+	if len(updates) == 0 && req.WorkspaceID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+		return
 	}
 
-	c.JSON(http.StatusOK, ChatDetailResponse{
+	chat, ok := findAccessibleChat(c, database.DB, chatID, user, RoleEditor)
+	if !ok {
+		return
+	}
+
+	if req.WorkspaceID != nil {
+		if chat.UserID != user.ID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the chat's creator can move it"})
+			return
+		}
+		if *req.WorkspaceID == "" {
+			updates["workspace_id"] = nil
+		} else {
+			if !requireWorkspaceRole(c, *req.WorkspaceID, user, RoleEditor) {
+				return
+			}
+			updates["workspace_id"] = *req.WorkspaceID
+		}
+	}
+
+	// UpdateColumns keeps updated_at tied to conversation activity, not housekeeping.
+	if err := database.DB.Model(&chat).UpdateColumns(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ChatHistoryResponse{
 		ID:        chat.ID,
 		Title:     chat.Title,
-		Messages:  messages,
+		Pinned:    chat.Pinned,
+		Archived:  chat.Archived,
 		CreatedAt: chat.CreatedAt,
+		UpdatedAt: chat.UpdatedAt,
 	})
 }
 
-func DeleteChat(c *gin.Context) {
-	chatID := c.Param("id")
-	clerkUserID := c.GetHeader("X-User-ID")
-
-	if clerkUserID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+func DeleteMessage(c *gin.Context) {
+	messageID := c.Param("id")
+	user, ok := authenticatedUser(c)
+	if !ok {
 		return
 	}
 
-	var user models.User
-	if err := database.DB.Where("clerk_id = ?", clerkUserID).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+	var message models.Message
+	if err := database.DB.Where("id = ?", messageID).First(&message).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
 		return
 	}
 
-	result := database.DB.Where("id = ? AND user_id = ?", chatID, user.ID).Delete(&models.Chat{})
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete chat"})
+	if _, ok := findAccessibleChat(c, database.DB, message.ChatID, user, RoleEditor); !ok {
 		return
 	}
 
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "chat not found"})
+	if err := database.DB.Delete(&message).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete message"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "chat deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "message deleted successfully"})
 }
 
 func CreateOrGetUser(c *gin.Context) {
 	var req struct {
-		ClerkID  string `json:"clerk_id" binding:"required"`
+		ClerkID  string `json:"clerk_id"`
 		Email    string `json:"email" binding:"required"`
 		FullName string `json:"full_name"`
 	}
@@ -541,14 +716,20 @@ func CreateOrGetUser(c *gin.Context) {
 		return
 	}
 
+	clerkUserID := c.GetString(middleware.UserIDKey)
+	if req.ClerkID != "" && req.ClerkID != clerkUserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "clerk_id does not match authenticated user"})
+		return
+	}
+
 	var user models.User
-	result := database.DB.Where("clerk_id = ?", req.ClerkID).First(&user)
+	result := database.DB.Where("clerk_id = ?", clerkUserID).First(&user)
 
 	if result.Error != nil {
 		user = models.User{
 			ID:       uuid.New().String(),
-			ClerkID:  req.ClerkID,
-			Email:    req.Email,
+			ClerkID:  clerkUserID,
+			Email:    nullableEmail(req.Email),
 			FullName: req.FullName,
 		}
 		if err := database.DB.Create(&user).Error; err != nil {
@@ -560,6 +741,23 @@ func CreateOrGetUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+func authenticatedUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+
+	clerkUserID := c.GetString(middleware.UserIDKey)
+	if clerkUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return user, false
+	}
+
+	if err := database.DB.Where("clerk_id = ?", clerkUserID).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return user, false
+	}
+
+	return user, true
+}
+
 func truncateTitle(prompt string) string {
 	if len(prompt) > 50 {
 		return prompt[:47] + "..."
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/prompts"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+	"golang.org/x/sync/errgroup"
+)
+
+const maxGenerationRetries = 2
+
+const (
+	outcomeSuccess       = "success"
+	outcomeLLMError      = "llm_error"
+	outcomeExtractFailed = "extract_failed"
+	outcomePreflight     = "preflight_failed"
+	outcomeRenderError   = "render_error"
+	outcomeTooShort      = "too_short"
+	outcomeTooLong       = "too_long"
+	outcomeEstimateShort = "estimate_too_short"
+	outcomeEstimateLong  = "estimate_too_long"
+	outcomeUploadFailed  = "upload_failed"
+)
+
+const maxAttemptErrorLength = 2000
+
+type generation struct {
+	RunID           string
+	PromptVersion   string
+	Style           string
+	Target          durationTarget
+	Code            string
+	Explanation     string
+	ExplanationData *models.StructuredExplanation
+	VideoURL        string
+	ThumbnailURL    string
+	Duration        int
+}
+
+// recordAttempt stores the outcome of one pass through the pipeline so prompt
+// versions can be compared on success rate and retries.
+func recordAttempt(run generation, set prompts.Set, userID string, attempt int, outcome string, err error) {
+	record := models.GenerationAttempt{
+		RunID:         run.RunID,
+		UserID:        userID,
+		PromptVersion: set.Version,
+		ExperimentID:  set.ExperimentID,
+		Attempt:       attempt + 1,
+		Outcome:       outcome,
+	}
+	if err != nil {
+		record.Error = err.Error()
+		if len(record.Error) > maxAttemptErrorLength {
+			record.Error = record.Error[len(record.Error)-maxAttemptErrorLength:]
+		}
+	}
+	if dbErr := database.DB.Create(&record).Error; dbErr != nil {
+		fmt.Printf("Warning: failed to record generation attempt: %v\n", dbErr)
+	}
+}
+
+// generateAnimation runs the prompt through code generation, rendering and
+// upload, retrying with the failure as context when the scene is broken or too
+// short. The explanation is written concurrently and passed to onExplanation
+// as soon as it is ready; a scene that fails for good cancels it. Returned
+// errors are safe to show to the caller.
+func generateAnimation(ctx context.Context, prompt string, opts utils.RenderOptions, set prompts.Set, override complexityOverride, usage *models.UsageRecord, onExplanation func(string, *models.StructuredExplanation)) (generation, error) {
+	style, _ := utils.LookupStyle(opts.Style)
+	result := generation{RunID: uuid.New().String(), PromptVersion: set.Version, Style: style.Name}
+	g, gctx := errgroup.WithContext(ctx)
+
+	explanation := "Explanation unavailable"
+	var explanationData *models.StructuredExplanation
+	var explanationUsage tokenUsage
+	g.Go(func() error {
+		startTime := time.Now()
+		data, dataUsage, err := generateExplanation(gctx, set, prompt)
+		explanationUsage = dataUsage
+		if err != nil {
+			if gctx.Err() == nil {
+				fmt.Println("error generating explanation:", err)
+			}
+			return nil
+		}
+		explanation, explanationData = explanationText(data), data
+		fmt.Printf("generated explanation in [%s]\n", time.Since(startTime))
+		if onExplanation != nil {
+			onExplanation(explanation, explanationData)
+		}
+		return nil
+	})
+
+	g.Go(func() error {
+		return buildScene(gctx, prompt, opts, set, override, style, &result, usage)
+	})
+
+	err := g.Wait()
+	explanationUsage.addTo(usage)
+	result.Explanation, result.ExplanationData = explanation, explanationData
+	return result, err
+}
+
+func buildScene(ctx context.Context, prompt string, opts utils.RenderOptions, set prompts.Set, override complexityOverride, style utils.StylePreset, result *generation, usage *models.UsageRecord) error {
+	var lastError string
+
+	startTime := time.Now()
+	result.Target = resolveDurationTarget(ctx, prompt, override, usage)
+	target := result.Target
+	fmt.Printf("targeting %s complexity (%s), %d-%ds in [%s]\n", target.Complexity, target.Source, target.Min, target.Max, time.Since(startTime))
+
+	for attempt := 0; attempt <= maxGenerationRetries; attempt++ {
+		if attempt > 0 {
+			fmt.Printf("Retry attempt %d/%d\n", attempt, maxGenerationRetries)
+		}
+
+		startTime = time.Now()
+		content, codeUsage, err := generateManim(ctx, set, prompt, target, style.PromptGuide(), lastError)
+		codeUsage.addTo(usage)
+		if err != nil {
+			fmt.Println("error generating manim code:", err)
+			recordAttempt(*result, set, usage.UserID, attempt, outcomeLLMError, err)
+			return errors.New("failed to generate animation code")
+		}
+		fmt.Printf("generated manim code in [%s]\n", time.Since(startTime))
+
+		startTime = time.Now()
+		code := utils.ExtractCode(content)
+		if code == "" {
+			fmt.Println("error: could not extract code from response")
+			recordAttempt(*result, set, usage.UserID, attempt, outcomeExtractFailed, nil)
+			return errors.New("failed to extract animation code")
+		}
+		code = utils.ApplyStyle(code, style)
+		result.Code = code
+		fmt.Printf("extracted code in [%s]\n", time.Since(startTime))
+
+		// A confident static estimate that misses the bounds is repaired
+		// without rendering. The last attempt always renders, since the real
+		// duration is what counts.
+		if estimate := utils.EstimateDuration(code, opts.Scene); estimate.Exact && attempt < maxGenerationRetries {
+			seconds := int(math.Round(estimate.Seconds))
+			if seconds < target.EnforceMin {
+				fmt.Printf("Estimated duration (%ds) is below minimum, repairing before render\n", seconds)
+				recordAttempt(*result, set, usage.UserID, attempt, outcomeEstimateShort, fmt.Errorf("estimated %ds", seconds))
+				lastError = fmt.Sprintf("The play and wait calls in the scene only add up to about %d seconds, need at least %d seconds. Add content or lengthen run_time and self.wait() values", seconds, target.EnforceMin)
+				continue
+			}
+			if target.EnforceMax > 0 && seconds > target.EnforceMax {
+				fmt.Printf("Estimated duration (%ds) is above maximum, repairing before render\n", seconds)
+				recordAttempt(*result, set, usage.UserID, attempt, outcomeEstimateLong, fmt.Errorf("estimated %ds", seconds))
+				lastError = fmt.Sprintf("The play and wait calls in the scene add up to about %d seconds, it must be at most %d seconds. Shorten run_time and self.wait() values or remove content", seconds, target.EnforceMax)
+				continue
+			}
+		}
+
+		startTime = time.Now()
+		preflightCPU, err := utils.Preflight(ctx, code, opts)
+		usage.RenderCPUSeconds += preflightCPU
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Println("error in preflight:", err)
+			recordAttempt(*result, set, usage.UserID, attempt, outcomePreflight, err)
+			if attempt < maxGenerationRetries {
+				lastError = err.Error()
+				continue
+			}
+			return fmt.Errorf("animation generation failed: %v", err)
+		}
+		fmt.Printf("passed preflight in [%s]\n", time.Since(startTime))
+
+		startTime = time.Now()
+		render, err := utils.RunCode(ctx, code, opts)
+		usage.RenderCPUSeconds += render.CPUSeconds
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Println("error running code:", err)
+			recordAttempt(*result, set, usage.UserID, attempt, outcomeRenderError, err)
+
+			if isCoordinateError(err) && attempt < maxGenerationRetries {
+				lastError = err.Error()
+				fmt.Printf("Detected coordinate error, retrying with error context...\n")
+				continue
+			}
+
+			return fmt.Errorf("animation generation failed: %v", err)
+		}
+
+		result.Duration = render.Duration
+		if result.Duration < target.EnforceMin {
+			fmt.Printf("Warning: Video duration (%ds) is below minimum.\n", result.Duration)
+			recordAttempt(*result, set, usage.UserID, attempt, outcomeTooShort, fmt.Errorf("duration %ds", result.Duration))
+			utils.RemoveLocalRender(render.Path)
+
+			if attempt < maxGenerationRetries {
+				lastError = fmt.Sprintf("Video duration was only %d seconds, need at least %d seconds", result.Duration, target.EnforceMin)
+				continue
+			}
+
+			return fmt.Errorf("animation too short (%ds). Animations must be at least %d seconds", result.Duration, target.EnforceMin)
+		}
+		if target.EnforceMax > 0 && result.Duration > target.EnforceMax {
+			fmt.Printf("Warning: Video duration (%ds) is above maximum.\n", result.Duration)
+			recordAttempt(*result, set, usage.UserID, attempt, outcomeTooLong, fmt.Errorf("duration %ds", result.Duration))
+			utils.RemoveLocalRender(render.Path)
+
+			if attempt < maxGenerationRetries {
+				lastError = fmt.Sprintf("Video duration was %d seconds, it must be at most %d seconds", result.Duration, target.EnforceMax)
+				continue
+			}
+
+			return fmt.Errorf("animation too long (%ds). Animations must be at most %d seconds", result.Duration, target.EnforceMax)
+		}
+
+		fmt.Printf("ran code and measured duration (%ds) in [%s]\n", result.Duration, time.Since(startTime))
+
+		startTime = time.Now()
+		result.VideoURL, result.ThumbnailURL, err = storeRender(render, usage)
+		if err != nil {
+			fmt.Println("error uploading to s3:", err)
+			recordAttempt(*result, set, usage.UserID, attempt, outcomeUploadFailed, err)
+			return errors.New("failed to upload video")
+		}
+		fmt.Printf("uploaded to s3 in [%s]\n", time.Since(startTime))
+
+		recordAttempt(*result, set, usage.UserID, attempt, outcomeSuccess, nil)
+		break
+	}
+
+	return nil
+}
+
+// saveGeneration stores a finished generation as the assistant reply to
+// parentID and tracks the objects it references.
+func saveGeneration(chatID, parentID, prompt, promptKey string, result generation, usage *models.UsageRecord) (models.Message, error) {
+	message := models.Message{
+		ID:              uuid.New().String(),
+		ChatID:          chatID,
+		Role:            "assistant",
+		Content:         prompt,
+		VideoURL:        result.VideoURL,
+		ThumbnailURL:    result.ThumbnailURL,
+		Explanation:     result.Explanation,
+		ExplanationData: result.ExplanationData,
+		Duration:        result.Duration,
+		Code:            result.Code,
+		ParentID:        parentID,
+		Selected:        true,
+		Model:           generationModel,
+		PromptVersion:   result.PromptVersion,
+		Style:           result.Style,
+		Complexity:      result.Target.Complexity,
+		PromptKey:       promptKey,
+	}
+	if err := database.DB.Create(&message).Error; err != nil {
+		return message, err
+	}
+	usage.MessageID = message.ID
+	utils.TrackObject(message.ID, result.VideoURL, usage.StoredBytes)
+	utils.TrackObject(message.ID, result.ThumbnailURL, 0)
+
+	if err := database.DB.Model(&models.GenerationAttempt{}).Where("run_id = ?", result.RunID).
+		UpdateColumn("message_id", message.ID).Error; err != nil {
+		fmt.Printf("Warning: failed to link generation attempts to message %s: %v\n", message.ID, err)
+	}
+	return message, nil
+}
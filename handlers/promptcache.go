@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"strings"
+	"unicode"
+
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+)
+
+var promptStopWords = map[string]bool{
+	"i": true, "want": true, "to": true, "please": true, "of": true, "and": true,
+	"is": true, "are": true, "what": true, "how": true, "does": true, "do": true,
+	"in": true, "on": true, "for": true, "with": true, "about": true, "using": true,
+	"us": true, "could": true, "would": true, "video": true, "animation": true,
+}
+
+func normalizePrompt(prompt string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, prompt)
+
+	var words []string
+	for _, word := range strings.Fields(cleaned) {
+		if skipWords[word] || promptStopWords[word] {
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
+
+func promptCacheKey(prompt string, opts utils.RenderOptions) string {
+	normalized := normalizePrompt(prompt)
+	if normalized == "" {
+		return ""
+	}
+	key := normalized + "|" + opts.Quality + "|" + opts.Format
+	// Keys for the default style keep the old format so earlier generations
+	// can still be reused.
+	if opts.Style != "" && opts.Style != utils.DefaultStyle {
+		key += "|" + opts.Style
+	}
+	return key
+}
+
+func findReusableMessage(promptKey string) (models.Message, bool) {
+	var message models.Message
+	if promptKey == "" {
+		return message, false
+	}
+
+	// A message showing a hand-edited revision no longer matches its prompt, so
+	// only the generated video (revision 1, or never revised) is reused.
+	err := database.DB.Joins("JOIN chats ON chats.id = messages.chat_id AND chats.deleted_at IS NULL").
+		Where("messages.prompt_key = ? AND messages.role = ? AND messages.video_url <> ''", promptKey, "assistant").
+		Where("COALESCE(messages.revision, 0) <= 1").
+		Order("messages.created_at DESC").
+		First(&message).Error
+	if err != nil {
+		return message, false
+	}
+
+	if message.ReusedFromID != "" {
+		message.ID = message.ReusedFromID
+	}
+	return message, true
+}
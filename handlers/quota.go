@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+	"gorm.io/gorm"
+)
+
+type Plan struct {
+	GenerationsPerDay     int
+	RenderMinutesPerMonth int
+	MaxQuality            string
+}
+
+var plans = map[string]Plan{
+	"free": {GenerationsPerDay: 10, RenderMinutesPerMonth: 30, MaxQuality: "low"},
+	"pro":  {GenerationsPerDay: 100, RenderMinutesPerMonth: 600, MaxQuality: "high"},
+}
+
+var qualityRank = map[string]int{"low": 0, "medium": 1, "high": 2}
+
+func planFor(user models.User) Plan {
+	if plan, ok := plans[user.Plan]; ok {
+		return plan
+	}
+	return plans["free"]
+}
+
+var (
+	errGenerationQuota = errors.New("daily generation quota exceeded")
+	errRenderQuota     = errors.New("monthly render minutes exceeded")
+)
+
+func renderSecondsSince(tx *gorm.DB, userID string, since time.Time) (int64, error) {
+	// Deleted chats still count, otherwise deleting history would reset the quota.
+	var generated int64
+	err := tx.Unscoped().Model(&models.Message{}).
+		Joins("JOIN chats ON chats.id = messages.chat_id").
+		Where("chats.user_id = ? AND messages.role = ? AND COALESCE(messages.reused_from_id, '') = ''", userID, "assistant").
+		Where("messages.created_at >= ?", since).
+		Select("COALESCE(SUM(messages.duration), 0)").Scan(&generated).Error
+	if err != nil {
+		return 0, err
+	}
+
+	// Edited revisions are rendered on the same farm, so they draw on the same
+	// allowance. The original render copied in as revision 1 has no author.
+	var revised int64
+	err = tx.Model(&models.MessageRevision{}).
+		Where("author_id = ? AND created_at >= ?", userID, since).
+		Select("COALESCE(SUM(duration), 0)").Scan(&revised).Error
+	return generated + revised, err
+}
+
+// enforceQuota checks the user's plan and reserves one generation against the
+// daily allowance. Reservations are taken under a per-user lock so parallel
+// requests can't all pass the check, and they are kept when the generation
+// fails, since the LLM and render time were spent anyway. Requests rejected
+// before any of that work starts must release theirs.
+func enforceQuota(c *gin.Context, user models.User, opts utils.RenderOptions) (*models.QuotaReservation, bool) {
+	plan := planFor(user)
+
+	if qualityRank[opts.Quality] > qualityRank[plan.MaxQuality] {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s quality is not available on your plan", opts.Quality)})
+		return nil, false
+	}
+
+	now := time.Now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var generationsToday, renderSeconds int64
+	reservation := models.QuotaReservation{UserID: user.ID}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "quota:"+user.ID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.QuotaReservation{}).
+			Where("user_id = ? AND created_at >= ?", user.ID, dayStart).
+			Count(&generationsToday).Error; err != nil {
+			return err
+		}
+
+		var err error
+		if renderSeconds, err = renderSecondsSince(tx, user.ID, monthStart); err != nil {
+			return err
+		}
+
+		if generationsToday >= int64(plan.GenerationsPerDay) {
+			return errGenerationQuota
+		}
+		if math.Ceil(float64(renderSeconds)/60) >= float64(plan.RenderMinutesPerMonth) {
+			return errRenderQuota
+		}
+		if err := tx.Create(&reservation).Error; err != nil {
+			return err
+		}
+		generationsToday++
+		return nil
+	})
+
+	generationsLeft := plan.GenerationsPerDay - int(generationsToday)
+	minutesLeft := plan.RenderMinutesPerMonth - int(math.Ceil(float64(renderSeconds)/60))
+	c.Header("X-Quota-Generations-Remaining", strconv.Itoa(max(generationsLeft, 0)))
+	c.Header("X-Quota-Render-Minutes-Remaining", strconv.Itoa(max(minutesLeft, 0)))
+
+	switch {
+	case errors.Is(err, errGenerationQuota):
+		c.Header("Retry-After", strconv.Itoa(int(dayStart.AddDate(0, 0, 1).Sub(now).Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return nil, false
+	case errors.Is(err, errRenderQuota):
+		c.Header("Retry-After", strconv.Itoa(int(monthStart.AddDate(0, 1, 0).Sub(now).Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return nil, false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check quota"})
+		return nil, false
+	}
+
+	return &reservation, true
+}
+
+// releaseQuota hands back a reservation for work that turned out to be free,
+// such as serving a cached generation or a request rejected before it ran.
+func releaseQuota(reservation *models.QuotaReservation) {
+	if err := database.DB.Delete(reservation).Error; err != nil {
+		fmt.Println("error releasing quota reservation:", err)
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"gorm.io/gorm/clause"
+)
+
+const worstPromptsLimit = 20
+
+type RateMessageRequest struct {
+	Rating  string   `json:"rating" binding:"required,oneof=up down"`
+	Reasons []string `json:"reasons" binding:"omitempty,max=4,dive,oneof=wrong_math overlapping_text too_fast boring"`
+	Comment string   `json:"comment" binding:"max=2000"`
+}
+
+type RatingResponse struct {
+	MessageID     string    `json:"message_id"`
+	Rating        string    `json:"rating"`
+	Reasons       []string  `json:"reasons"`
+	Comment       string    `json:"comment,omitempty"`
+	Model         string    `json:"model,omitempty"`
+	PromptVersion string    `json:"prompt_version,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type RatingBucket struct {
+	PromptVersion string         `json:"prompt_version"`
+	Model         string         `json:"model"`
+	Total         int            `json:"total"`
+	Up            int            `json:"up"`
+	Down          int            `json:"down"`
+	ApprovalRate  float64        `json:"approval_rate"`
+	Reasons       map[string]int `json:"reasons"`
+}
+
+type RatedPrompt struct {
+	Prompt string `json:"prompt"`
+	Total  int    `json:"total"`
+	Down   int    `json:"down"`
+}
+
+type RatingReportResponse struct {
+	Since        time.Time      `json:"since"`
+	Buckets      []RatingBucket `json:"buckets"`
+	WorstPrompts []RatedPrompt  `json:"worst_prompts"`
+}
+
+func toRatingResponse(rating models.Rating) RatingResponse {
+	response := RatingResponse{
+		MessageID:     rating.MessageID,
+		Rating:        "up",
+		Reasons:       []string{},
+		Comment:       rating.Comment,
+		Model:         rating.Model,
+		PromptVersion: rating.PromptVersion,
+		UpdatedAt:     rating.UpdatedAt,
+	}
+	if rating.Value < 0 {
+		response.Rating = "down"
+	}
+	if rating.Reasons != "" {
+		response.Reasons = strings.Split(rating.Reasons, ",")
+	}
+	return response
+}
+
+func RateMessage(c *gin.Context) {
+	var req RateMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	message, ok := findAccessibleMessage(c, c.Param("id"), user, RoleViewer)
+	if !ok {
+		return
+	}
+
+	prompt := message.Content
+	if message.ParentID != "" {
+		var parent models.Message
+		if err := database.DB.Where("id = ?", message.ParentID).First(&parent).Error; err == nil {
+			prompt = parent.Content
+		}
+	}
+
+	rating := models.Rating{
+		MessageID:     message.ID,
+		UserID:        user.ID,
+		Value:         1,
+		Comment:       strings.TrimSpace(req.Comment),
+		Prompt:        prompt,
+		Model:         message.Model,
+		PromptVersion: message.PromptVersion,
+	}
+	if req.Rating == "down" {
+		rating.Value = -1
+	}
+
+	seen := map[string]bool{}
+	var reasons []string
+	for _, reason := range req.Reasons {
+		if !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	rating.Reasons = strings.Join(reasons, ",")
+
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "reasons", "comment", "updated_at"}),
+	}).Create(&rating).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save rating"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toRatingResponse(rating))
+}
+
+func DeleteRating(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	message, ok := findAccessibleMessage(c, c.Param("id"), user, RoleViewer)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Where("message_id = ? AND user_id = ?", message.ID, user.ID).Delete(&models.Rating{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete rating"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "rating removed"})
+}
+
+func GetRatingReport(c *gin.Context) {
+	since := time.Now().UTC().AddDate(0, 0, -30)
+	if raw := c.Query("since"); raw != "" {
+		parsed, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be YYYY-MM-DD"})
+			return
+		}
+		since = parsed
+	}
+
+	response := RatingReportResponse{Since: since, Buckets: []RatingBucket{}, WorstPrompts: []RatedPrompt{}}
+
+	var totals []struct {
+		PromptVersion string
+		Model         string
+		Total         int
+		Up            int
+		Down          int
+	}
+	err := database.DB.Model(&models.Rating{}).
+		Select(`prompt_version, model, COUNT(*) AS total,
+			COUNT(*) FILTER (WHERE value > 0) AS up,
+			COUNT(*) FILTER (WHERE value < 0) AS down`).
+		Where("created_at >= ?", since).
+		Group("prompt_version, model").
+		Order("prompt_version, model").
+		Scan(&totals).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build report"})
+		return
+	}
+
+	var reasonCounts []struct {
+		PromptVersion string
+		Model         string
+		Reason        string
+		Total         int
+	}
+	err = database.DB.Raw(`SELECT prompt_version, model, reason, COUNT(*) AS total
+		FROM ratings, unnest(string_to_array(reasons, ',')) AS reason
+		WHERE reasons <> '' AND created_at >= ?
+		GROUP BY prompt_version, model, reason`, since).
+		Scan(&reasonCounts).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build report"})
+		return
+	}
+
+	for _, t := range totals {
+		bucket := RatingBucket{
+			PromptVersion: t.PromptVersion,
+			Model:         t.Model,
+			Total:         t.Total,
+			Up:            t.Up,
+			Down:          t.Down,
+			Reasons:       map[string]int{},
+		}
+		if t.Total > 0 {
+			bucket.ApprovalRate = float64(t.Up) / float64(t.Total)
+		}
+		for _, rc := range reasonCounts {
+			if rc.PromptVersion == t.PromptVersion && rc.Model == t.Model {
+				bucket.Reasons[rc.Reason] = rc.Total
+			}
+		}
+		response.Buckets = append(response.Buckets, bucket)
+	}
+
+	err = database.DB.Model(&models.Rating{}).
+		Select("prompt, COUNT(*) AS total, COUNT(*) FILTER (WHERE value < 0) AS down").
+		Where("created_at >= ?", since).
+		Group("prompt").
+		Having("COUNT(*) FILTER (WHERE value < 0) > 0").
+		Order("down DESC, total DESC").
+		Limit(worstPromptsLimit).
+		Scan(&response.WorstPrompts).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+)
+
+const renderErrorTail = 4000
+
+type RenderRequest struct {
+	Code        string `json:"code" binding:"required"`
+	SceneName   string `json:"scene_name"`
+	Title       string `json:"title"`
+	ChatID      string `json:"chat_id"`
+	WorkspaceID string `json:"workspace_id"`
+	Quality     string `json:"quality" binding:"omitempty,oneof=low medium high"`
+	Format      string `json:"format" binding:"omitempty,oneof=mp4 webm gif mov"`
+}
+
+// renderFailure keeps the end of manim's output, which is where the traceback
+// the user needs to fix their scene ends up.
+func renderFailure(err error) string {
+	msg := err.Error()
+	if len(msg) > renderErrorTail {
+		msg = "…" + msg[len(msg)-renderErrorTail:]
+	}
+	return msg
+}
+
+func HandleRender(c *gin.Context) {
+	var req RenderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	renderOpts := utils.RenderOptions{
+		Quality:   req.Quality,
+		Format:    req.Format,
+		Scene:     req.SceneName,
+		Untrusted: true,
+	}.WithDefaults()
+
+	if err := utils.SandboxAvailable(); err != nil {
+		fmt.Println("Warning: refusing to run user code:", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "custom code rendering is not available on this server"})
+		return
+	}
+
+	code := utils.NormalizeCode(req.Code)
+	if err := utils.ValidateUserCode(code, renderOpts.Scene); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reservation, ok := enforceQuota(c, user, renderOpts)
+	if !ok {
+		return
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = renderOpts.Scene
+	}
+	chat, ok := resolveChat(c, user, req.ChatID, req.WorkspaceID, truncateTitle(title))
+	if !ok {
+		releaseQuota(reservation)
+		return
+	}
+
+	usage := models.UsageRecord{UserID: user.ID}
+	defer recordUsage(&usage)
+
+	render, err := utils.RunCode(c.Request.Context(), code, renderOpts)
+	usage.RenderCPUSeconds += render.CPUSeconds
+	if err != nil {
+		fmt.Println("error running user code:", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "render failed", "details": renderFailure(err)})
+		return
+	}
+
+	videoURL, thumbnailURL, err := storeRender(render, &usage)
+	if err != nil {
+		fmt.Println("error uploading to s3:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload video"})
+		return
+	}
+
+	message := models.Message{
+		ID:           uuid.New().String(),
+		ChatID:       chat.ID,
+		Role:         "assistant",
+		Content:      fmt.Sprintf("Rendered %s from your code", renderOpts.Scene),
+		VideoURL:     videoURL,
+		ThumbnailURL: thumbnailURL,
+		Duration:     render.Duration,
+		Code:         code,
+	}
+	if err := database.DB.Create(&message).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save response"})
+		return
+	}
+	usage.MessageID = message.ID
+	utils.TrackObject(message.ID, videoURL, usage.StoredBytes)
+	utils.TrackObject(message.ID, thumbnailURL, 0)
+
+	c.JSON(http.StatusOK, ChatResponse{
+		ChatID:       chat.ID,
+		MessageID:    message.ID,
+		VideoURL:     videoURL,
+		ThumbnailURL: thumbnailURL,
+		Duration:     render.Duration,
+		CreatedAt:    message.CreatedAt,
+	})
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const diffContextLines = 3
+
+type CreateRevisionRequest struct {
+	Code      string `json:"code" binding:"required"`
+	SceneName string `json:"scene_name"`
+	Quality   string `json:"quality" binding:"omitempty,oneof=low medium high"`
+	Format    string `json:"format" binding:"omitempty,oneof=mp4 webm gif mov"`
+	Promote   bool   `json:"promote"`
+}
+
+type RevisionResponse struct {
+	ID           string    `json:"id"`
+	Number       int       `json:"number"`
+	AuthorID     string    `json:"author_id"`
+	Code         string    `json:"code"`
+	VideoURL     string    `json:"video_url,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	Duration     int       `json:"duration,omitempty"`
+	Current      bool      `json:"current"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type RevisionDiffResponse struct {
+	From    int              `json:"from"`
+	To      int              `json:"to"`
+	Added   int              `json:"added"`
+	Removed int              `json:"removed"`
+	Unified string           `json:"unified"`
+	Lines   []utils.DiffLine `json:"lines"`
+}
+
+func toRevisionResponse(rev models.MessageRevision, message models.Message) RevisionResponse {
+	return RevisionResponse{
+		ID:           rev.ID,
+		Number:       rev.Number,
+		AuthorID:     rev.AuthorID,
+		Code:         rev.Code,
+		VideoURL:     rev.VideoURL,
+		ThumbnailURL: rev.ThumbnailURL,
+		Duration:     rev.Duration,
+		Current:      rev.Number == message.Revision,
+		CreatedAt:    rev.CreatedAt,
+	}
+}
+
+// findAccessibleMessage loads an assistant message and checks the caller's role
+// on its chat, writing the error response itself like findAccessibleChat.
+func findAccessibleMessage(c *gin.Context, messageID string, user models.User, minRole string) (models.Message, bool) {
+	var message models.Message
+	if err := database.DB.Where("id = ? AND role = ?", messageID, "assistant").First(&message).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return message, false
+	}
+	if _, ok := findAccessibleChat(c, database.DB, message.ChatID, user, minRole); !ok {
+		return message, false
+	}
+	return message, true
+}
+
+func findRevision(c *gin.Context, messageID, raw string) (models.MessageRevision, bool) {
+	var rev models.MessageRevision
+	number, err := strconv.Atoi(raw)
+	if err != nil || number <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid revision number"})
+		return rev, false
+	}
+	if err := database.DB.Where("message_id = ? AND number = ?", messageID, number).First(&rev).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
+		return rev, false
+	}
+	return rev, true
+}
+
+// promoteRevision makes a revision the message's current video and code.
+func promoteRevision(tx *gorm.DB, message *models.Message, rev models.MessageRevision) error {
+	updates := map[string]interface{}{
+		"code":          rev.Code,
+		"video_url":     rev.VideoURL,
+		"thumbnail_url": rev.ThumbnailURL,
+		"duration":      rev.Duration,
+		"revision":      rev.Number,
+	}
+	return tx.Model(message).UpdateColumns(updates).Error
+}
+
+func CreateRevision(c *gin.Context) {
+	var req CreateRevisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	message, ok := findAccessibleMessage(c, c.Param("id"), user, RoleEditor)
+	if !ok {
+		return
+	}
+
+	renderOpts := utils.RenderOptions{
+		Quality:   req.Quality,
+		Format:    req.Format,
+		Scene:     req.SceneName,
+		Untrusted: true,
+	}.WithDefaults()
+
+	if err := utils.SandboxAvailable(); err != nil {
+		fmt.Println("Warning: refusing to run user code:", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "custom code rendering is not available on this server"})
+		return
+	}
+
+	code := utils.NormalizeCode(req.Code)
+	if err := utils.ValidateUserCode(code, renderOpts.Scene); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, ok := enforceQuota(c, user, renderOpts); !ok {
+		return
+	}
+
+	usage := models.UsageRecord{UserID: user.ID, MessageID: message.ID}
+	defer recordUsage(&usage)
+
+	render, err := utils.RunCode(c.Request.Context(), code, renderOpts)
+	usage.RenderCPUSeconds += render.CPUSeconds
+	if err != nil {
+		fmt.Println("error running revised code:", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "render failed", "details": renderFailure(err)})
+		return
+	}
+
+	videoURL, thumbnailURL, err := storeRender(render, &usage)
+	if err != nil {
+		fmt.Println("error uploading to s3:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload video"})
+		return
+	}
+	utils.TrackObject(message.ID, videoURL, usage.StoredBytes)
+	utils.TrackObject(message.ID, thumbnailURL, 0)
+
+	rev := models.MessageRevision{
+		ID:           uuid.New().String(),
+		MessageID:    message.ID,
+		AuthorID:     user.ID,
+		Code:         code,
+		VideoURL:     videoURL,
+		ThumbnailURL: thumbnailURL,
+		Duration:     render.Duration,
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the message so concurrent edits get consecutive numbers.
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, "id = ?", message.ID).Error; err != nil {
+			return err
+		}
+
+		var latest int
+		if err := tx.Model(&models.MessageRevision{}).Where("message_id = ?", message.ID).
+			Select("COALESCE(MAX(number), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+
+		// The first edit also records the original render as revision 1 so
+		// it can be diffed against and promoted back.
+		if latest == 0 {
+			original := models.MessageRevision{
+				ID:           uuid.New().String(),
+				MessageID:    message.ID,
+				Number:       1,
+				Code:         message.Code,
+				VideoURL:     message.VideoURL,
+				ThumbnailURL: message.ThumbnailURL,
+				Duration:     message.Duration,
+				CreatedAt:    message.CreatedAt,
+			}
+			if err := tx.Create(&original).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&message).UpdateColumn("revision", 1).Error; err != nil {
+				return err
+			}
+			latest = 1
+		}
+
+		rev.Number = latest + 1
+		if err := tx.Create(&rev).Error; err != nil {
+			return err
+		}
+		if req.Promote {
+			return promoteRevision(tx, &message, rev)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save revision"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, toRevisionResponse(rev, message))
+}
+
+func GetRevisions(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	message, ok := findAccessibleMessage(c, c.Param("id"), user, RoleViewer)
+	if !ok {
+		return
+	}
+
+	var revisions []models.MessageRevision
+	if err := database.DB.Where("message_id = ?", message.ID).Order("number ASC").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch revisions"})
+		return
+	}
+
+	response := make([]RevisionResponse, len(revisions))
+	for i, rev := range revisions {
+		response[i] = toRevisionResponse(rev, message)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func DiffRevisions(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	message, ok := findAccessibleMessage(c, c.Param("id"), user, RoleViewer)
+	if !ok {
+		return
+	}
+
+	from, ok := findRevision(c, message.ID, c.Query("from"))
+	if !ok {
+		return
+	}
+	to, ok := findRevision(c, message.ID, c.Query("to"))
+	if !ok {
+		return
+	}
+
+	lines, err := utils.DiffLines(from.Code, to.Code)
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	response := RevisionDiffResponse{
+		From:    from.Number,
+		To:      to.Number,
+		Lines:   lines,
+		Unified: utils.UnifiedDiff(fmt.Sprintf("revision %d", from.Number), fmt.Sprintf("revision %d", to.Number), lines, diffContextLines),
+	}
+	for _, line := range lines {
+		switch line.Op {
+		case utils.DiffInsert:
+			response.Added++
+		case utils.DiffDelete:
+			response.Removed++
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func PromoteRevision(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	message, ok := findAccessibleMessage(c, c.Param("id"), user, RoleEditor)
+	if !ok {
+		return
+	}
+
+	rev, ok := findRevision(c, message.ID, c.Param("number"))
+	if !ok {
+		return
+	}
+
+	if err := promoteRevision(database.DB, &message, rev); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to promote revision"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toRevisionResponse(rev, message))
+}
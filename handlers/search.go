@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// These expressions must stay identical to the GIN indexes created in db.Connect.
+const (
+	chatSearchVector    = "to_tsvector('english', chats.title)"
+	messageSearchVector = "to_tsvector('english', coalesce(messages.content, '') || ' ' || coalesce(messages.explanation, ''))"
+	searchQuery         = "websearch_to_tsquery('english', ?)"
+	headlineOptions     = "'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15'"
+)
+
+type chatCursor struct {
+	Pinned    bool      `json:"p"`
+	UpdatedAt time.Time `json:"u"`
+	ID        string    `json:"id"`
+}
+
+func encodeCursor(chat models.Chat) string {
+	b, _ := json.Marshal(chatCursor{Pinned: chat.Pinned, UpdatedAt: chat.UpdatedAt, ID: chat.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (chatCursor, error) {
+	var cursor chatCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+func pageSize(raw string) int {
+	size, err := strconv.Atoi(raw)
+	if err != nil || size <= 0 {
+		return defaultPageSize
+	}
+	return min(size, maxPageSize)
+}
+
+func applyCursor(query *gorm.DB, cursor chatCursor) *gorm.DB {
+	return query.Where("(chats.pinned, chats.updated_at, chats.id) < (?, ?, ?)", cursor.Pinned, cursor.UpdatedAt, cursor.ID)
+}
+
+func applySearch(query *gorm.DB, q string) *gorm.DB {
+	return query.Where(
+		chatSearchVector+" @@ "+searchQuery+
+			" OR EXISTS (SELECT 1 FROM messages WHERE messages.chat_id = chats.id AND messages.deleted_at IS NULL AND "+
+			messageSearchVector+" @@ "+searchQuery+")",
+		q, q,
+	)
+}
+
+func attachSearchMatches(response []ChatHistoryResponse, q string) error {
+	if len(response) == 0 {
+		return nil
+	}
+
+	chatIDs := make([]string, len(response))
+	byID := make(map[string]*ChatHistoryResponse, len(response))
+	for i := range response {
+		chatIDs[i] = response[i].ID
+		byID[response[i].ID] = &response[i]
+	}
+
+	var messageMatches []struct {
+		ID      string
+		ChatID  string
+		Snippet string
+	}
+	err := database.DB.Model(&models.Message{}).
+		Select("messages.id, messages.chat_id, ts_headline('english', coalesce(messages.content, '') || ' ' || coalesce(messages.explanation, ''), "+searchQuery+", "+headlineOptions+") AS snippet", q).
+		Where("messages.chat_id IN ? AND "+messageSearchVector+" @@ "+searchQuery, chatIDs, q).
+		Order("messages.created_at ASC").
+		Scan(&messageMatches).Error
+	if err != nil {
+		return err
+	}
+
+	for _, match := range messageMatches {
+		chat := byID[match.ChatID]
+		chat.MatchingMessageIDs = append(chat.MatchingMessageIDs, match.ID)
+		if chat.Snippet == "" {
+			chat.Snippet = match.Snippet
+		}
+	}
+
+	var titleMatches []struct {
+		ID      string
+		Snippet string
+	}
+	err = database.DB.Model(&models.Chat{}).
+		Select("chats.id, ts_headline('english', chats.title, "+searchQuery+", "+headlineOptions+") AS snippet", q).
+		Where("chats.id IN ? AND "+chatSearchVector+" @@ "+searchQuery, chatIDs, q).
+		Scan(&titleMatches).Error
+	if err != nil {
+		return err
+	}
+
+	for _, match := range titleMatches {
+		chat := byID[match.ID]
+		chat.TitleHighlight = match.Snippet
+		if chat.Snippet == "" {
+			chat.Snippet = match.Snippet
+		}
+	}
+
+	return nil
+}
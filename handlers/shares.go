@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"gorm.io/gorm"
+)
+
+type CreateShareRequest struct {
+	ChatID    string `json:"chat_id" binding:"required"`
+	MessageID string `json:"message_id"`
+}
+
+type ShareResponse struct {
+	Token     string     `json:"token"`
+	URL       string     `json:"url,omitempty"`
+	ChatID    string     `json:"chat_id"`
+	MessageID string     `json:"message_id,omitempty"`
+	ViewCount int        `json:"view_count"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type SharedMessageResponse struct {
+	ID           string    `json:"id"`
+	VideoURL     string    `json:"video_url,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	Explanation  string    `json:"explanation,omitempty"`
+	Duration     int       `json:"duration,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type SharedChatResponse struct {
+	Title     string                  `json:"title"`
+	Messages  []SharedMessageResponse `json:"messages"`
+	ViewCount int                     `json:"view_count"`
+	CreatedAt time.Time               `json:"created_at"`
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func toShareResponse(link models.ShareLink) ShareResponse {
+	return ShareResponse{
+		Token:     link.Token,
+		URL:       shareURL(link.Token),
+		ChatID:    link.ChatID,
+		MessageID: link.MessageID,
+		ViewCount: link.ViewCount,
+		RevokedAt: link.RevokedAt,
+		CreatedAt: link.CreatedAt,
+	}
+}
+
+func CreateShare(c *gin.Context) {
+	var req CreateShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	chat, ok := findAccessibleChat(c, database.DB, req.ChatID, user, RoleEditor)
+	if !ok {
+		return
+	}
+
+	if req.MessageID != "" {
+		var message models.Message
+		if err := database.DB.Where("id = ? AND chat_id = ? AND role = ?", req.MessageID, chat.ID, "assistant").First(&message).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create share link"})
+		return
+	}
+
+	link := models.ShareLink{
+		ID:        uuid.New().String(),
+		Token:     token,
+		UserID:    user.ID,
+		ChatID:    chat.ID,
+		MessageID: req.MessageID,
+	}
+	if err := database.DB.Create(&link).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create share link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toShareResponse(link))
+}
+
+func GetShares(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Where("user_id = ?", user.ID)
+	if chatID := c.Query("chat_id"); chatID != "" {
+		query = query.Where("chat_id = ?", chatID)
+	}
+
+	var links []models.ShareLink
+	if err := query.Order("created_at DESC").Find(&links).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch share links"})
+		return
+	}
+
+	response := make([]ShareResponse, len(links))
+	for i, link := range links {
+		response[i] = toShareResponse(link)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func RevokeShare(c *gin.Context) {
+	token := c.Param("token")
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	result := database.DB.Model(&models.ShareLink{}).
+		Where("token = ? AND user_id = ? AND revoked_at IS NULL", token, user.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke share link"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "share link not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "share link revoked successfully"})
+}
+
+// loadShare resolves an active token to its chat and the shared assistant
+// messages; a message-level share yields exactly that one message.
+func loadShare(token string) (models.ShareLink, models.Chat, []models.Message, error) {
+	var link models.ShareLink
+	var chat models.Chat
+	var messages []models.Message
+
+	if err := database.DB.Where("token = ? AND revoked_at IS NULL", token).First(&link).Error; err != nil {
+		return link, chat, messages, err
+	}
+
+	if err := database.DB.Where("id = ?", link.ChatID).First(&chat).Error; err != nil {
+		return link, chat, messages, err
+	}
+
+	query := database.DB.Where("chat_id = ? AND role = ?", chat.ID, "assistant")
+	if link.MessageID != "" {
+		query = query.Where("id = ?", link.MessageID)
+	}
+	if err := query.Order("created_at ASC").Find(&messages).Error; err != nil {
+		return link, chat, messages, err
+	}
+	if link.MessageID == "" {
+		messages = conversationPath(messages)
+	}
+	if len(messages) == 0 {
+		return link, chat, messages, gorm.ErrRecordNotFound
+	}
+
+	return link, chat, messages, nil
+}
+
+func GetSharedChat(c *gin.Context) {
+	link, chat, messages, err := loadShare(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "share link not found"})
+		return
+	}
+
+	database.DB.Model(&link).UpdateColumn("view_count", gorm.Expr("view_count + 1"))
+
+	response := SharedChatResponse{
+		Title:     chat.Title,
+		Messages:  make([]SharedMessageResponse, len(messages)),
+		ViewCount: link.ViewCount + 1,
+		CreatedAt: chat.CreatedAt,
+	}
+	for i, msg := range messages {
+		response.Messages[i] = SharedMessageResponse{
+			ID:           msg.ID,
+			VideoURL:     msg.VideoURL,
+			ThumbnailURL: msg.ThumbnailURL,
+			Explanation:  msg.Explanation,
+			Duration:     msg.Duration,
+			CreatedAt:    msg.CreatedAt,
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
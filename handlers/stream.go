@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genai"
+)
+
+const titlePrompt = `Write a short title, at most six words, for a chat that starts with this request for an educational animation.
+Reply with the title only, without quotes or punctuation at the end.
+
+Request: %s`
+
+// generateResponder answers a generate request either with one JSON body or,
+// when the client asked to stream, as server-sent events so the explanation
+// and title can be shown while the video is still rendering.
+type generateResponder struct {
+	c      *gin.Context
+	stream bool
+	mu     sync.Mutex
+}
+
+func newGenerateResponder(c *gin.Context, stream bool) *generateResponder {
+	r := &generateResponder{c: c, stream: stream}
+	if stream {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+	}
+	return r
+}
+
+// event sends an intermediate update. It is a no-op for non-streaming clients
+// and safe to call from the pipeline's goroutines.
+func (r *generateResponder) event(name string, data any) {
+	if !r.stream {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.c.SSEvent(name, data)
+	r.c.Writer.Flush()
+}
+
+func (r *generateResponder) fail(status int, message string) {
+	if r.stream {
+		r.event("error", gin.H{"error": message})
+		return
+	}
+	r.c.JSON(status, gin.H{"error": message})
+}
+
+func (r *generateResponder) done(response ChatResponse) {
+	if r.stream {
+		r.event("result", response)
+		return
+	}
+	r.c.JSON(http.StatusOK, response)
+}
+
+func generateChatTitle(ctx context.Context, prompt string) (string, tokenUsage, error) {
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		return "", tokenUsage{}, fmt.Errorf("env not set")
+	}
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey})
+	if err != nil {
+		return "", tokenUsage{}, err
+	}
+
+	result, err := client.Models.GenerateContent(ctx, generationModel, genai.Text(fmt.Sprintf(titlePrompt, prompt)), nil)
+	if err != nil {
+		return "", tokenUsage{}, err
+	}
+
+	usage := usageFromResponse(result)
+	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil || len(result.Candidates[0].Content.Parts) == 0 {
+		return "", usage, fmt.Errorf("unexpected response format")
+	}
+
+	title, _, _ := strings.Cut(strings.TrimSpace(result.Candidates[0].Content.Parts[0].Text), "\n")
+	title = strings.Trim(strings.TrimSpace(title), "\"'*#.")
+	if title == "" {
+		return "", usage, fmt.Errorf("empty title")
+	}
+	return truncateTitle(title), usage, nil
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+)
+
+type UpdateUserRequest struct {
+	DefaultStyle string `json:"default_style" binding:"required,oneof=3b1b whiteboard chalkboard high-contrast brand"`
+}
+
+func resolveStyle(requested string, user models.User) string {
+	if requested != "" {
+		return requested
+	}
+	if utils.IsStyle(user.DefaultStyle) {
+		return user.DefaultStyle
+	}
+	return utils.DefaultStyle
+}
+
+func GetStyles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"default": utils.DefaultStyle, "styles": utils.StylePresets()})
+}
+
+func UpdateCurrentUser(c *gin.Context) {
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("default_style", req.DefaultStyle).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
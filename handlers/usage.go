@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"google.golang.org/genai"
+)
+
+type tokenUsage struct {
+	PromptTokens int
+	OutputTokens int
+}
+
+func usageFromResponse(result *genai.GenerateContentResponse) tokenUsage {
+	var usage tokenUsage
+	if result == nil || result.UsageMetadata == nil {
+		return usage
+	}
+	if result.UsageMetadata.PromptTokenCount != nil {
+		usage.PromptTokens = int(*result.UsageMetadata.PromptTokenCount)
+	}
+	if result.UsageMetadata.CandidatesTokenCount != nil {
+		usage.OutputTokens = int(*result.UsageMetadata.CandidatesTokenCount)
+	}
+	return usage
+}
+
+func (u tokenUsage) addTo(record *models.UsageRecord) {
+	record.PromptTokens += u.PromptTokens
+	record.OutputTokens += u.OutputTokens
+}
+
+func recordUsage(record *models.UsageRecord) {
+	if record.PromptTokens == 0 && record.OutputTokens == 0 && record.RenderCPUSeconds == 0 && record.StoredBytes == 0 {
+		return
+	}
+	if err := database.DB.Create(record).Error; err != nil {
+		fmt.Println("error recording usage:", err)
+	}
+}
+
+type usagePrices struct {
+	InputPerMillionTokens  float64
+	OutputPerMillionTokens float64
+	PerCPUHour             float64
+	PerGBMonth             float64
+}
+
+// Defaults are gemini-2.0-flash list prices and rough on-demand compute/S3 rates.
+func pricesFromEnv() usagePrices {
+	return usagePrices{
+		InputPerMillionTokens:  envFloat("USAGE_PRICE_INPUT_PER_MTOK", 0.10),
+		OutputPerMillionTokens: envFloat("USAGE_PRICE_OUTPUT_PER_MTOK", 0.40),
+		PerCPUHour:             envFloat("USAGE_PRICE_CPU_HOUR", 0.05),
+		PerGBMonth:             envFloat("USAGE_PRICE_GB_MONTH", 0.025),
+	}
+}
+
+func envFloat(key string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && value >= 0 {
+		return value
+	}
+	return fallback
+}
+
+type UsageBucket struct {
+	PeriodStart      time.Time `json:"period_start"`
+	Generations      int       `json:"generations"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	OutputTokens     int       `json:"output_tokens"`
+	RenderCPUSeconds float64   `json:"render_cpu_seconds"`
+	StoredBytes      int64     `json:"stored_bytes"`
+	EstimatedCost    float64   `json:"estimated_cost"`
+}
+
+type UsageResponse struct {
+	Period  string        `json:"period"`
+	Buckets []UsageBucket `json:"buckets"`
+	Total   UsageBucket   `json:"total"`
+}
+
+func (p usagePrices) estimate(b UsageBucket) float64 {
+	return float64(b.PromptTokens)/1e6*p.InputPerMillionTokens +
+		float64(b.OutputTokens)/1e6*p.OutputPerMillionTokens +
+		b.RenderCPUSeconds/3600*p.PerCPUHour +
+		float64(b.StoredBytes)/(1<<30)*p.PerGBMonth
+}
+
+func GetUsage(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	period := c.DefaultQuery("period", "day")
+	var since time.Time
+	switch period {
+	case "day":
+		since = time.Now().UTC().AddDate(0, 0, -30)
+	case "month":
+		since = time.Now().UTC().AddDate(-1, 0, 0)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day or month"})
+		return
+	}
+
+	var buckets []UsageBucket
+	err := database.DB.Model(&models.UsageRecord{}).
+		Select(`date_trunc(?, created_at) AS period_start,
+			COUNT(NULLIF(message_id, '')) AS generations,
+			COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens,
+			COALESCE(SUM(output_tokens), 0) AS output_tokens,
+			COALESCE(SUM(render_cpu_seconds), 0) AS render_cpu_seconds,
+			COALESCE(SUM(stored_bytes), 0) AS stored_bytes`, period).
+		Where("user_id = ? AND created_at >= ?", user.ID, since).
+		Group("period_start").
+		Order("period_start DESC").
+		Scan(&buckets).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch usage"})
+		return
+	}
+
+	prices := pricesFromEnv()
+	response := UsageResponse{Period: period, Buckets: buckets}
+	for i := range response.Buckets {
+		bucket := &response.Buckets[i]
+		bucket.EstimatedCost = prices.estimate(*bucket)
+
+		response.Total.Generations += bucket.Generations
+		response.Total.PromptTokens += bucket.PromptTokens
+		response.Total.OutputTokens += bucket.OutputTokens
+		response.Total.RenderCPUSeconds += bucket.RenderCPUSeconds
+		response.Total.StoredBytes += bucket.StoredBytes
+		response.Total.EstimatedCost += bucket.EstimatedCost
+	}
+	if response.Buckets == nil {
+		response.Buckets = []UsageBucket{}
+	}
+	response.Total.PeriodStart = since
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+	"gorm.io/gorm"
+)
+
+const maxWebhookBody = 1 << 20
+
+type clerkEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type clerkUserData struct {
+	ID                    string  `json:"id"`
+	FirstName             *string `json:"first_name"`
+	LastName              *string `json:"last_name"`
+	PrimaryEmailAddressID string  `json:"primary_email_address_id"`
+	EmailAddresses        []struct {
+		ID           string `json:"id"`
+		EmailAddress string `json:"email_address"`
+	} `json:"email_addresses"`
+}
+
+func (d clerkUserData) primaryEmail() string {
+	for _, address := range d.EmailAddresses {
+		if address.ID == d.PrimaryEmailAddressID {
+			return address.EmailAddress
+		}
+	}
+	if len(d.EmailAddresses) > 0 {
+		return d.EmailAddresses[0].EmailAddress
+	}
+	return ""
+}
+
+// nullableEmail stores users without an address as NULL so they don't collide
+// on the unique email index.
+func nullableEmail(email string) *string {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil
+	}
+	return &email
+}
+
+func (d clerkUserData) fullName() string {
+	var parts []string
+	for _, name := range []*string{d.FirstName, d.LastName} {
+		if name != nil && *name != "" {
+			parts = append(parts, *name)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func HandleClerkWebhook(c *gin.Context) {
+	secret := os.Getenv("CLERK_WEBHOOK_SECRET")
+	if secret == "" {
+		fmt.Println("error: CLERK_WEBHOOK_SECRET not set")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "webhook not configured"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if err := utils.VerifySvixSignature(secret, c.Request.Header, body, time.Now()); err != nil {
+		fmt.Println("rejected webhook:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid signature"})
+		return
+	}
+
+	var event clerkEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	var data clerkUserData
+	if err := json.Unmarshal(event.Data, &data); err != nil || data.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	switch event.Type {
+	case "user.created", "user.updated":
+		err = upsertClerkUser(data)
+	case "user.deleted":
+		err = deleteClerkUser(data.ID)
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "event ignored"})
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("error handling %s for %s: %v\n", event.Type, data.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process event"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "event processed"})
+}
+
+func upsertClerkUser(data clerkUserData) error {
+	var user models.User
+	err := database.DB.Unscoped().Where("clerk_id = ?", data.ID).First(&user).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user = models.User{
+			ID:       uuid.New().String(),
+			ClerkID:  data.ID,
+			Email:    nullableEmail(data.primaryEmail()),
+			FullName: data.fullName(),
+		}
+		return database.DB.Create(&user).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	// Svix can deliver a late user.updated after user.deleted; don't resurrect the account.
+	if user.DeletedAt.Valid {
+		return nil
+	}
+
+	return database.DB.Model(&user).Updates(map[string]interface{}{
+		"email":     nullableEmail(data.primaryEmail()),
+		"full_name": data.fullName(),
+	}).Error
+}
+
+func deleteClerkUser(clerkID string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Where("clerk_id = ?", clerkID).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Chat{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+type CreateWorkspaceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type AddMemberRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role" binding:"required,oneof=owner editor viewer"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner editor viewer"`
+}
+
+type WorkspaceResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MemberResponse struct {
+	UserID   string    `json:"user_id"`
+	Email    string    `json:"email"`
+	FullName string    `json:"full_name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func workspaceRole(workspaceID, userID string) string {
+	var member models.WorkspaceMember
+	err := database.DB.Joins("JOIN workspaces ON workspaces.id = workspace_members.workspace_id AND workspaces.deleted_at IS NULL").
+		Where("workspace_members.workspace_id = ? AND workspace_members.user_id = ?", workspaceID, userID).
+		First(&member).Error
+	if err != nil {
+		return ""
+	}
+	return member.Role
+}
+
+// chatRole is the caller's effective role on a chat. Workspace chats follow
+// the membership, even for their creator, so leaving or being demoted takes
+// effect on them too; personal chats belong to their creator alone.
+func chatRole(chat models.Chat, userID string) string {
+	if chat.WorkspaceID != nil {
+		return workspaceRole(*chat.WorkspaceID, userID)
+	}
+	if chat.UserID == userID {
+		return RoleOwner
+	}
+	return ""
+}
+
+// findAccessibleChat loads a chat and writes the error response itself when the
+// caller's role is below minRole. Chats the caller cannot see at all are
+// reported as missing so their existence doesn't leak.
+func findAccessibleChat(c *gin.Context, query *gorm.DB, chatID string, user models.User, minRole string) (models.Chat, bool) {
+	var chat models.Chat
+	if err := query.Where("id = ?", chatID).First(&chat).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "chat not found"})
+		return chat, false
+	}
+
+	role := chatRole(chat, user.ID)
+	if role == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "chat not found"})
+		return chat, false
+	}
+	if roleRank[role] < roleRank[minRole] {
+		c.JSON(http.StatusForbidden, gin.H{"error": "insufficient workspace role"})
+		return chat, false
+	}
+
+	return chat, true
+}
+
+func requireWorkspaceRole(c *gin.Context, workspaceID string, user models.User, minRole string) bool {
+	role := workspaceRole(workspaceID, user.ID)
+	if role == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "workspace not found"})
+		return false
+	}
+	if roleRank[role] < roleRank[minRole] {
+		c.JSON(http.StatusForbidden, gin.H{"error": "insufficient workspace role"})
+		return false
+	}
+	return true
+}
+
+func CreateWorkspace(c *gin.Context) {
+	var req CreateWorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	workspace := models.Workspace{
+		ID:      uuid.New().String(),
+		Name:    strings.TrimSpace(req.Name),
+		OwnerID: user.ID,
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&workspace).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: user.ID, Role: RoleOwner}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create workspace"})
+		return
+	}
+
+	c.JSON(http.StatusOK, WorkspaceResponse{
+		ID:        workspace.ID,
+		Name:      workspace.Name,
+		Role:      RoleOwner,
+		CreatedAt: workspace.CreatedAt,
+	})
+}
+
+func GetWorkspaces(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var response []WorkspaceResponse
+	err := database.DB.Model(&models.Workspace{}).
+		Select("workspaces.id, workspaces.name, workspace_members.role, workspaces.created_at").
+		Joins("JOIN workspace_members ON workspace_members.workspace_id = workspaces.id").
+		Where("workspace_members.user_id = ?", user.ID).
+		Order("workspaces.name ASC").
+		Scan(&response).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch workspaces"})
+		return
+	}
+	if response == nil {
+		response = []WorkspaceResponse{}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func GetWorkspaceMembers(c *gin.Context) {
+	workspaceID := c.Param("id")
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	if !requireWorkspaceRole(c, workspaceID, user, RoleViewer) {
+		return
+	}
+
+	var response []MemberResponse
+	err := database.DB.Model(&models.WorkspaceMember{}).
+		Select("users.id AS user_id, COALESCE(users.email, '') AS email, users.full_name, workspace_members.role, workspace_members.created_at AS joined_at").
+		Joins("JOIN users ON users.id = workspace_members.user_id AND users.deleted_at IS NULL").
+		Where("workspace_members.workspace_id = ?", workspaceID).
+		Order("workspace_members.created_at ASC").
+		Scan(&response).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func AddWorkspaceMember(c *gin.Context) {
+	workspaceID := c.Param("id")
+
+	var req AddMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	if !requireWorkspaceRole(c, workspaceID, user, RoleOwner) {
+		return
+	}
+
+	var invitee models.User
+	if err := database.DB.Where("email = ?", strings.TrimSpace(req.Email)).First(&invitee).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	member := models.WorkspaceMember{WorkspaceID: workspaceID, UserID: invitee.ID, Role: req.Role}
+	if err := database.DB.Create(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) || workspaceRole(workspaceID, invitee.ID) != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "user is already a member"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MemberResponse{
+		UserID:   invitee.ID,
+		Email:    *invitee.Email,
+		FullName: invitee.FullName,
+		Role:     member.Role,
+		JoinedAt: member.CreatedAt,
+	})
+}
+
+func UpdateWorkspaceMember(c *gin.Context) {
+	workspaceID := c.Param("id")
+	memberID := c.Param("user_id")
+
+	var req UpdateMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	if !requireWorkspaceRole(c, workspaceID, user, RoleOwner) {
+		return
+	}
+
+	if req.Role != RoleOwner {
+		lastOwner, err := isLastOwner(workspaceID, memberID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update member"})
+			return
+		}
+		if lastOwner {
+			c.JSON(http.StatusConflict, gin.H{"error": "workspace must keep at least one owner"})
+			return
+		}
+	}
+
+	result := database.DB.Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND user_id = ?", workspaceID, memberID).
+		Update("role", req.Role)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update member"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "member updated successfully"})
+}
+
+func RemoveWorkspaceMember(c *gin.Context) {
+	workspaceID := c.Param("id")
+	memberID := c.Param("user_id")
+
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	// Anyone may leave; only owners may remove others.
+	minRole := RoleOwner
+	if memberID == user.ID {
+		minRole = RoleViewer
+	}
+	if !requireWorkspaceRole(c, workspaceID, user, minRole) {
+		return
+	}
+
+	lastOwner, err := isLastOwner(workspaceID, memberID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove member"})
+		return
+	}
+	if lastOwner {
+		c.JSON(http.StatusConflict, gin.H{"error": "workspace must keep at least one owner"})
+		return
+	}
+
+	result := database.DB.Where("workspace_id = ? AND user_id = ?", workspaceID, memberID).Delete(&models.WorkspaceMember{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove member"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "member removed successfully"})
+}
+
+func isLastOwner(workspaceID, userID string) (bool, error) {
+	if workspaceRole(workspaceID, userID) != RoleOwner {
+		return false, nil
+	}
+
+	var owners int64
+	if err := database.DB.Model(&models.WorkspaceMember{}).Where("workspace_id = ? AND role = ?", workspaceID, RoleOwner).Count(&owners).Error; err != nil {
+		return false, err
+	}
+	return owners <= 1, nil
+}
@@ -0,0 +1,154 @@
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+	"gorm.io/gorm"
+)
+
+const purgeBatchSize = 100
+
+func TrashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func StartPurge(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			cutoff := time.Now().Add(-TrashRetention())
+
+			if purged, err := PurgeDeletedChats(cutoff); err != nil {
+				log.Println("Purge of deleted chats failed:", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d deleted chats\n", purged)
+			}
+
+			if purged, err := PurgeDeletedMessages(cutoff); err != nil {
+				log.Println("Purge of deleted messages failed:", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d deleted messages\n", purged)
+			}
+
+			if purged, err := PurgeDeletedUsers(cutoff); err != nil {
+				log.Println("Purge of deleted users failed:", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d deleted users\n", purged)
+			}
+
+			<-ticker.C
+		}
+	}()
+}
+
+func PurgeDeletedChats(cutoff time.Time) (int, error) {
+	total := 0
+	for {
+		var chatIDs []string
+		err := database.DB.Unscoped().Model(&models.Chat{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Limit(purgeBatchSize).
+			Pluck("id", &chatIDs).Error
+		if err != nil {
+			return total, fmt.Errorf("could not list deleted chats: %w", err)
+		}
+		if len(chatIDs) == 0 {
+			return total, nil
+		}
+
+		var messageIDs []string
+		if err := database.DB.Unscoped().Model(&models.Message{}).Where("chat_id IN ?", chatIDs).Pluck("id", &messageIDs).Error; err != nil {
+			return total, fmt.Errorf("could not list messages of deleted chats: %w", err)
+		}
+		var keys []string
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if keys, err = utils.DropMessageObjects(tx, messageIDs); err != nil {
+				return err
+			}
+			if err := tx.Where("chat_id IN ?", chatIDs).Delete(&models.ShareLink{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("message_id IN ?", messageIDs).Delete(&models.MessageRevision{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("message_id IN ?", messageIDs).Delete(&models.Rating{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("chat_id IN ?", chatIDs).Delete(&models.Message{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("id IN ?", chatIDs).Delete(&models.Chat{}).Error
+		})
+		if err != nil {
+			return total, fmt.Errorf("could not purge chats: %w", err)
+		}
+		utils.DeleteUnreferencedObjects(keys)
+		total += len(chatIDs)
+	}
+}
+
+func PurgeDeletedMessages(cutoff time.Time) (int, error) {
+	total := 0
+	for {
+		var messageIDs []string
+		err := database.DB.Unscoped().Model(&models.Message{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Limit(purgeBatchSize).
+			Pluck("id", &messageIDs).Error
+		if err != nil {
+			return total, fmt.Errorf("could not list deleted messages: %w", err)
+		}
+		if len(messageIDs) == 0 {
+			return total, nil
+		}
+
+		var keys []string
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if keys, err = utils.DropMessageObjects(tx, messageIDs); err != nil {
+				return err
+			}
+			if err := tx.Where("message_id IN ?", messageIDs).Delete(&models.ShareLink{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("message_id IN ?", messageIDs).Delete(&models.MessageRevision{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("message_id IN ?", messageIDs).Delete(&models.Rating{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("id IN ?", messageIDs).Delete(&models.Message{}).Error
+		})
+		if err != nil {
+			return total, fmt.Errorf("could not purge messages: %w", err)
+		}
+		utils.DeleteUnreferencedObjects(keys)
+		total += len(messageIDs)
+	}
+}
+
+// PurgeDeletedUsers removes accounts once every chat they owned has been purged.
+func PurgeDeletedUsers(cutoff time.Time) (int, error) {
+	result := database.DB.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM chats WHERE chats.user_id = users.id)").
+		Delete(&models.User{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("could not purge users: %w", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
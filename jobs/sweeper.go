@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
+)
+
+// Objects younger than this may belong to a generation that has uploaded but
+// not yet saved its message.
+const orphanGracePeriod = 24 * time.Hour
+
+type SweepResult struct {
+	Backfilled      int
+	OrphansDeleted  int
+	DanglingRemoved int
+}
+
+func StartOrphanSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			<-ticker.C
+			result, err := SweepOrphans(time.Now().Add(-orphanGracePeriod))
+			if err != nil {
+				log.Println("Orphan sweep failed:", err)
+				continue
+			}
+			log.Printf("Orphan sweep: backfilled %d references, deleted %d orphans, removed %d dangling references\n",
+				result.Backfilled, result.OrphansDeleted, result.DanglingRemoved)
+		}
+	}()
+}
+
+func SweepOrphans(olderThan time.Time) (SweepResult, error) {
+	var result SweepResult
+
+	backfilled, err := backfillReferences()
+	if err != nil {
+		return result, err
+	}
+	result.Backfilled = backfilled
+
+	objects, err := utils.ListBucketObjects()
+	if err != nil {
+		return result, err
+	}
+
+	var referencedKeys []string
+	if err := database.DB.Model(&models.StoredObject{}).Distinct().Pluck("object_key", &referencedKeys).Error; err != nil {
+		return result, fmt.Errorf("could not list referenced objects: %w", err)
+	}
+	referenced := make(map[string]bool, len(referencedKeys))
+	for _, key := range referencedKeys {
+		referenced[key] = true
+	}
+
+	inBucket := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		inBucket[object.Key] = true
+		if referenced[object.Key] || object.LastModified.After(olderThan) {
+			continue
+		}
+
+		// The reference list may be stale by now; re-check under the object lock.
+		deleted, err := utils.DeleteIfUnreferenced(object.Key)
+		if err != nil {
+			log.Println("Warning:", err)
+			continue
+		}
+		if deleted {
+			result.OrphansDeleted++
+		}
+	}
+
+	var dangling []string
+	for _, key := range referencedKeys {
+		if !inBucket[key] {
+			dangling = append(dangling, key)
+		}
+	}
+	if len(dangling) > 0 {
+		log.Printf("Warning: %d referenced objects are missing from the bucket\n", len(dangling))
+		removed := database.DB.Where("object_key IN ? AND created_at < ?", dangling, olderThan).Delete(&models.StoredObject{})
+		if removed.Error != nil {
+			return result, fmt.Errorf("could not remove dangling references: %w", removed.Error)
+		}
+		if err := database.DB.Where("object_key IN ?", dangling).Delete(&models.RenderCache{}).Error; err != nil {
+			return result, fmt.Errorf("could not clear dangling render cache entries: %w", err)
+		}
+		result.DanglingRemoved = int(removed.RowsAffected)
+	}
+
+	return result, nil
+}
+
+// backfillReferences records objects for messages saved before tracking existed.
+func backfillReferences() (int, error) {
+	var messages []models.Message
+	err := database.DB.Unscoped().
+		Where("video_url <> '' AND NOT EXISTS (SELECT 1 FROM stored_objects WHERE stored_objects.message_id = messages.id)").
+		Find(&messages).Error
+	if err != nil {
+		return 0, fmt.Errorf("could not find untracked messages: %w", err)
+	}
+
+	backfilled := 0
+	for _, message := range messages {
+		if _, ok := utils.ObjectKeyFromURL(message.VideoURL); ok {
+			utils.TrackObject(message.ID, message.VideoURL, 0)
+			backfilled++
+		}
+	}
+	return backfilled, nil
+}
@@ -2,26 +2,55 @@ package main
 
 import (
 	"log"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	database "github.com/tabishnaqvi1311/manimbot-backend/db"
 	"github.com/tabishnaqvi1311/manimbot-backend/handlers"
+	"github.com/tabishnaqvi1311/manimbot-backend/jobs"
+	"github.com/tabishnaqvi1311/manimbot-backend/middleware"
+	"github.com/tabishnaqvi1311/manimbot-backend/prompts"
+	"github.com/tabishnaqvi1311/manimbot-backend/utils"
 )
 
 func main() {
+	authConfig := middleware.AuthConfigFromEnv()
+	if err := authConfig.Validate(); err != nil {
+		log.Fatal("Invalid auth configuration:", err)
+	}
+
 	if err := database.Connect(); err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
+	if err := prompts.Seed(); err != nil {
+		log.Fatal("Failed to seed prompt templates:", err)
+	}
+
+	if err := handlers.LoadComplexityRulesFromEnv(); err != nil {
+		log.Fatal("Invalid complexity rules:", err)
+	}
+
+	if os.Getenv("PUBLIC_BASE_URL") == "" {
+		log.Println("Warning: PUBLIC_BASE_URL is not set, share pages and embeds are disabled")
+	}
+
+	if err := utils.SandboxAvailable(); err != nil {
+		log.Println("Warning: custom code rendering is disabled:", err)
+	}
+
+	jobs.StartPurge(time.Hour)
+	jobs.StartOrphanSweeper(24 * time.Hour)
+
 	router := gin.Default()
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000", "https://feynman-tech.vercel.app"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-User-ID"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-Quota-Generations-Remaining", "X-Quota-Render-Minutes-Remaining", "X-Next-Cursor"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -30,13 +59,60 @@ func main() {
 		c.JSON(200, gin.H{"status": "healthy"})
 	})
 
-	api := router.Group("/api")
+	router.POST("/webhooks/clerk", handlers.HandleClerkWebhook)
+	router.GET("/public/shares/:token", handlers.GetSharedChat)
+	router.GET("/s/:token", handlers.GetShareLanding)
+	router.GET("/embed/:token", handlers.GetEmbedPlayer)
+	router.GET("/oembed", handlers.GetOEmbed)
+
+	generateLimiter := middleware.RateLimiterFromEnv()
+
+	api := router.Group("/api", middleware.Auth(authConfig))
 	{
 		api.POST("/users", handlers.CreateOrGetUser)
-		api.POST("/generate", handlers.HandleGenerate)
+		api.PATCH("/users/me", handlers.UpdateCurrentUser)
+		api.GET("/styles", handlers.GetStyles)
+		api.POST("/generate", middleware.RateLimit(generateLimiter), handlers.HandleGenerate)
+		api.POST("/render", middleware.RateLimit(generateLimiter), handlers.HandleRender)
 		api.GET("/chats", handlers.GetChatHistory)
+		api.GET("/chats/trash", handlers.GetTrash)
 		api.GET("/chats/:id", handlers.GetChatDetail)
+		api.GET("/chats/:id/export", handlers.ExportChat)
+		api.PUT("/chats/:id", handlers.UpdateChat)
+		api.PATCH("/chats/:id", handlers.UpdateChat)
 		api.DELETE("/chats/:id", handlers.DeleteChat)
+		api.POST("/chats/:id/restore", handlers.RestoreChat)
+		api.DELETE("/messages/:id", handlers.DeleteMessage)
+		api.POST("/messages/:id/revisions", middleware.RateLimit(generateLimiter), handlers.CreateRevision)
+		api.GET("/messages/:id/revisions", handlers.GetRevisions)
+		api.GET("/messages/:id/revisions/diff", handlers.DiffRevisions)
+		api.POST("/messages/:id/revisions/:number/promote", handlers.PromoteRevision)
+		api.POST("/messages/:id/regenerate", middleware.RateLimit(generateLimiter), handlers.RegenerateMessage)
+		api.POST("/messages/:id/select", handlers.SelectMessage)
+		api.PUT("/messages/:id/rating", handlers.RateMessage)
+		api.DELETE("/messages/:id/rating", handlers.DeleteRating)
+		api.POST("/shares", handlers.CreateShare)
+		api.GET("/shares", handlers.GetShares)
+		api.DELETE("/shares/:token", handlers.RevokeShare)
+		api.GET("/usage", handlers.GetUsage)
+		api.POST("/workspaces", handlers.CreateWorkspace)
+		api.GET("/workspaces", handlers.GetWorkspaces)
+		api.GET("/workspaces/:id/members", handlers.GetWorkspaceMembers)
+		api.POST("/workspaces/:id/members", handlers.AddWorkspaceMember)
+		api.PATCH("/workspaces/:id/members/:user_id", handlers.UpdateWorkspaceMember)
+		api.DELETE("/workspaces/:id/members/:user_id", handlers.RemoveWorkspaceMember)
+	}
+
+	admin := api.Group("/admin", middleware.RequireAdmin(middleware.AdminIDsFromEnv()))
+	{
+		admin.GET("/ratings/report", handlers.GetRatingReport)
+		admin.GET("/prompts", handlers.GetPromptTemplates)
+		admin.POST("/prompts", handlers.CreatePromptTemplate)
+		admin.POST("/prompts/:version/default", handlers.SetDefaultPromptTemplate)
+		admin.GET("/prompts/report", handlers.GetPromptReport)
+		admin.GET("/experiments", handlers.GetExperiments)
+		admin.POST("/experiments", handlers.CreateExperiment)
+		admin.POST("/experiments/:id/stop", handlers.StopExperiment)
 	}
 
 	log.Println("Server starting on :8000")
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AdminIDsFromEnv() map[string]bool {
+	ids := map[string]bool{}
+	for _, id := range strings.Split(os.Getenv("ADMIN_CLERK_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// RequireAdmin must run after Auth; it only admits the configured Clerk user IDs.
+func RequireAdmin(adminIDs map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !adminIDs[c.GetString(UserIDKey)] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testIssuer   = "https://issuer.example"
+	testAudience = "manimbot"
+)
+
+// jwksStandIn serves whatever keys it currently holds and counts fetches, so
+// tests can rotate keys and check that the middleware refetches.
+type jwksStandIn struct {
+	mu      sync.Mutex
+	keys    []jsonWebKey
+	fetches int
+	server  *httptest.Server
+}
+
+func newJWKSStandIn(t *testing.T, keys ...jsonWebKey) *jwksStandIn {
+	s := &jwksStandIn{keys: keys}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *jwksStandIn) setKeys(keys ...jsonWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksStandIn) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) jsonWebKey {
+	return jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig", N: encodeInt(key.N), E: encodeInt(big.NewInt(int64(key.E)))}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) jsonWebKey {
+	return jsonWebKey{Kty: "EC", Kid: kid, Crv: key.Curve.Params().Name, X: encodeInt(key.X), Y: encodeInt(key.Y)}
+}
+
+func segment(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	signed := segment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(t, claims)
+	hashType := crypto.SHA256
+	switch alg[len(alg)-3:] {
+	case "384":
+		hashType = crypto.SHA384
+	case "512":
+		hashType = crypto.SHA512
+	}
+	h := hashType.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, hashType, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"sub": "user_123",
+		"iss": testIssuer,
+		"aud": testAudience,
+		"exp": now.Add(time.Hour).Unix(),
+		"nbf": now.Add(-time.Minute).Unix(),
+	}
+}
+
+func withClaim(name string, value interface{}) map[string]interface{} {
+	claims := validClaims()
+	if value == nil {
+		delete(claims, name)
+	} else {
+		claims[name] = value
+	}
+	return claims
+}
+
+func TestVerifyToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := newJWKSStandIn(t, rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey), ecJWK("ec-521", ec521Key))
+	cfg := AuthConfig{JWKSURL: jwks.server.URL, Issuer: testIssuer, Audience: testAudience, KeyCacheTTL: time.Hour}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"valid RS256", signToken(t, "RS256", "rsa-1", rsaKey, validClaims()), ""},
+		{"valid ES256", signToken(t, "ES256", "ec-1", ecKey, validClaims()), ""},
+		{"valid ES512", signToken(t, "ES512", "ec-521", ec521Key, validClaims()), ""},
+		{"audience list", signToken(t, "RS256", "rsa-1", rsaKey, withClaim("aud", []string{"other", testAudience})), ""},
+		{"wrong issuer", signToken(t, "RS256", "rsa-1", rsaKey, withClaim("iss", "https://evil.example")), "unexpected issuer"},
+		{"wrong audience", signToken(t, "RS256", "rsa-1", rsaKey, withClaim("aud", "other")), "audience"},
+		{"expired", signToken(t, "RS256", "rsa-1", rsaKey, withClaim("exp", time.Now().Add(-time.Hour).Unix())), "expired"},
+		{"no expiry", signToken(t, "RS256", "rsa-1", rsaKey, withClaim("exp", nil)), "no expiry"},
+		{"not yet valid", signToken(t, "RS256", "rsa-1", rsaKey, withClaim("nbf", time.Now().Add(time.Hour).Unix())), "not yet valid"},
+		{"no subject", signToken(t, "RS256", "rsa-1", rsaKey, withClaim("sub", nil)), "no subject"},
+		{"RS256 header on EC key", signToken(t, "RS256", "ec-1", ecKey, validClaims()), "does not match"},
+		{"ES256 header on RSA key", signToken(t, "ES256", "rsa-1", rsaKey, validClaims()), "does not match"},
+		{"ES384 header on P-256 key", signToken(t, "ES384", "ec-1", ecKey, validClaims()), "does not match"},
+		{"ES256 header on P-521 key", signToken(t, "ES256", "ec-521", ec521Key, validClaims()), "does not match"},
+		{"HS256", signToken(t, "HS256", "rsa-1", rsaKey, validClaims()), "unsupported algorithm"},
+		{"none", signToken(t, "none", "rsa-1", rsaKey, validClaims()), "unsupported algorithm"},
+		{"malformed", "not-a-token", "malformed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := newKeySet(cfg.JWKSURL, cfg.Issuer, cfg.KeyCacheTTL)
+			subject, err := verifyToken(tt.token, keys, cfg, time.Now())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if subject != "user_123" {
+					t.Fatalf("subject = %q, want user_123", subject)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenTamperedSignature(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks := newJWKSStandIn(t, rsaJWK("rsa-1", rsaKey))
+	cfg := AuthConfig{JWKSURL: jwks.server.URL, KeyCacheTTL: time.Hour}
+
+	token := signToken(t, "RS256", "rsa-1", otherKey, validClaims())
+	if _, err := verifyToken(token, newKeySet(cfg.JWKSURL, "", cfg.KeyCacheTTL), cfg, time.Now()); err == nil {
+		t.Fatal("token signed by another key was accepted")
+	}
+}
+
+func TestUnknownKidRefetchesAfterRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks := newJWKSStandIn(t, rsaJWK("old", oldKey))
+	cfg := AuthConfig{JWKSURL: jwks.server.URL, KeyCacheTTL: time.Hour}
+	keys := newKeySet(cfg.JWKSURL, "", cfg.KeyCacheTTL)
+
+	if _, err := verifyToken(signToken(t, "RS256", "old", oldKey, validClaims()), keys, cfg, time.Now()); err != nil {
+		t.Fatalf("old key: %v", err)
+	}
+	if jwks.fetchCount() != 1 {
+		t.Fatalf("fetches = %d, want 1", jwks.fetchCount())
+	}
+
+	// The issuer rotates; pretend the refetch throttle has passed.
+	jwks.setKeys(rsaJWK("new", newKey))
+	keys.lastAttempt = time.Now().Add(-2 * minRefreshInterval)
+
+	if _, err := verifyToken(signToken(t, "RS256", "new", newKey, validClaims()), keys, cfg, time.Now()); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if jwks.fetchCount() != 2 {
+		t.Fatalf("fetches = %d, want 2 after unknown kid", jwks.fetchCount())
+	}
+
+	// Cached and fresh keys don't trigger another fetch.
+	if _, err := verifyToken(signToken(t, "RS256", "new", newKey, validClaims()), keys, cfg, time.Now()); err != nil {
+		t.Fatalf("cached key: %v", err)
+	}
+	if jwks.fetchCount() != 2 {
+		t.Fatalf("fetches = %d, want 2 for cached key", jwks.fetchCount())
+	}
+
+	// Unknown kids inside the throttle window are rejected without hammering
+	// the issuer.
+	_, err := verifyToken(signToken(t, "RS256", "missing", newKey, validClaims()), keys, cfg, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("error = %v, want unknown signing key", err)
+	}
+	if jwks.fetchCount() != 2 {
+		t.Fatalf("fetches = %d, want 2 while throttled", jwks.fetchCount())
+	}
+}
+
+func TestPublicKeyRejectsBadExponent(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, e := range []*big.Int{big.NewInt(1), new(big.Int).Lsh(big.NewInt(1), 64)} {
+		jwk := rsaJWK("rsa", key)
+		jwk.E = encodeInt(e)
+		if _, err := jwk.publicKey(); err == nil {
+			t.Fatalf("exponent %s was accepted", e)
+		}
+	}
+}
+
+func TestAuthUserIDHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jwks := newJWKSStandIn(t)
+
+	tests := []struct {
+		name string
+		cfg  AuthConfig
+		want int
+	}{
+		{"trusted without JWKS", AuthConfig{TrustUserIDHeader: true}, http.StatusOK},
+		{"ignored with JWKS", AuthConfig{JWKSURL: jwks.server.URL, KeyCacheTTL: time.Hour, TrustUserIDHeader: true}, http.StatusUnauthorized},
+		{"not trusted", AuthConfig{}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/", Auth(tt.cfg), func(c *gin.Context) {
+				c.String(http.StatusOK, c.GetString(UserIDKey))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-User-ID", "user_123")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -7,34 +7,212 @@ import (
 )
 
 type User struct {
-	ID        string         `gorm:"primaryKey" json:"id"`
-	ClerkID   string         `gorm:"uniqueIndex;not null" json:"clerk_id"`
-	Email     string         `gorm:"uniqueIndex;not null" json:"email"`
-	FullName  string         `json:"full_name"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
-	Chats     []Chat         `gorm:"foreignKey:UserID" json:"chats,omitempty"`
+	ID           string         `gorm:"primaryKey" json:"id"`
+	ClerkID      string         `gorm:"uniqueIndex;not null" json:"clerk_id"`
+	Email        *string        `gorm:"uniqueIndex:idx_users_active_email,where:deleted_at IS NULL" json:"email"`
+	FullName     string         `json:"full_name"`
+	Plan         string         `gorm:"not null;default:free" json:"plan"`
+	DefaultStyle string         `gorm:"not null;default:3b1b" json:"default_style"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	Chats        []Chat         `gorm:"foreignKey:UserID" json:"chats,omitempty"`
 }
 
 type Chat struct {
-	ID        string         `gorm:"primaryKey" json:"id"`
-	UserID    string         `gorm:"not null;index" json:"user_id"`
-	Title     string         `json:"title"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
-	Messages  []Message      `gorm:"foreignKey:ChatID" json:"messages,omitempty"`
-}
-
-type Message struct {
 	ID          string         `gorm:"primaryKey" json:"id"`
-	ChatID      string         `gorm:"not null;index" json:"chat_id"`
-	Role        string         `gorm:"not null" json:"role"`
-	Content     string         `gorm:"type:text" json:"content"`
-	VideoURL    string         `json:"video_url,omitempty"`
-	Explanation string         `gorm:"type:text" json:"explanation,omitempty"`
-	Duration    int            `json:"duration,omitempty"`
+	UserID      string         `gorm:"not null;index" json:"user_id"`
+	WorkspaceID *string        `gorm:"index" json:"workspace_id,omitempty"`
+	Title       string         `json:"title"`
+	Pinned      bool           `gorm:"not null;default:false" json:"pinned"`
+	Archived    bool           `gorm:"not null;default:false;index" json:"archived"`
 	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	Messages    []Message      `gorm:"foreignKey:ChatID" json:"messages,omitempty"`
+}
+
+type Message struct {
+	ID              string                 `gorm:"primaryKey" json:"id"`
+	ChatID          string                 `gorm:"not null;index" json:"chat_id"`
+	Role            string                 `gorm:"not null" json:"role"`
+	Content         string                 `gorm:"type:text" json:"content"`
+	VideoURL        string                 `json:"video_url,omitempty"`
+	ThumbnailURL    string                 `json:"thumbnail_url,omitempty"`
+	Explanation     string                 `gorm:"type:text" json:"explanation,omitempty"`
+	ExplanationData *StructuredExplanation `gorm:"type:jsonb;serializer:json" json:"explanation_data,omitempty"`
+	Duration        int                    `json:"duration,omitempty"`
+	Code            string                 `gorm:"type:text" json:"code,omitempty"`
+	Revision        int                    `json:"revision,omitempty"`
+	ParentID        string                 `gorm:"index" json:"parent_id,omitempty"`
+	Selected        bool                   `gorm:"not null;default:false" json:"selected"`
+	Model           string                 `json:"model,omitempty"`
+	PromptVersion   string                 `gorm:"index" json:"prompt_version,omitempty"`
+	Style           string                 `json:"style,omitempty"`
+	Complexity      string                 `json:"complexity,omitempty"`
+	PromptKey       string                 `gorm:"index" json:"-"`
+	ReusedFromID    string                 `gorm:"index" json:"reused_from_id,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+	DeletedAt       gorm.DeletedAt         `gorm:"index" json:"-"`
+}
+
+type StructuredExplanation struct {
+	Summary        string               `json:"summary"`
+	Sections       []ExplanationSection `json:"sections"`
+	KeyTerms       []KeyTerm            `json:"key_terms"`
+	Equations      []Equation           `json:"equations"`
+	Prerequisites  []string             `json:"prerequisites"`
+	FurtherReading []string             `json:"further_reading"`
+}
+
+type ExplanationSection struct {
+	Heading string `json:"heading"`
+	Body    string `json:"body"`
+}
+
+type KeyTerm struct {
+	Term       string `json:"term"`
+	Definition string `json:"definition"`
+}
+
+type Equation struct {
+	LaTeX       string `json:"latex"`
+	Description string `json:"description"`
+}
+
+type RenderCache struct {
+	Hash         string    `gorm:"primaryKey" json:"hash"`
+	ObjectKey    string    `gorm:"index" json:"object_key"`
+	VideoURL     string    `json:"video_url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	Quality      string    `json:"quality"`
+	Format       string    `json:"format"`
+	Duration     int       `json:"duration"`
+	Size         int64     `json:"size"`
+	HitCount     int       `json:"hit_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type UsageRecord struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	UserID           string    `gorm:"not null;index" json:"user_id"`
+	MessageID        string    `gorm:"index" json:"message_id,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	OutputTokens     int       `json:"output_tokens"`
+	RenderCPUSeconds float64   `json:"render_cpu_seconds"`
+	StoredBytes      int64     `json:"stored_bytes"`
+	CreatedAt        time.Time `gorm:"index" json:"created_at"`
+}
+
+// QuotaReservation is taken for every generation or render a user starts,
+// whether or not it succeeds, and is what the daily quota counts.
+type QuotaReservation struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"not null;index" json:"user_id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+type StoredObject struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MessageID string    `gorm:"not null;index" json:"message_id"`
+	ObjectKey string    `gorm:"not null;index" json:"object_key"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ShareLink struct {
+	ID        string     `gorm:"primaryKey" json:"id"`
+	Token     string     `gorm:"uniqueIndex;not null" json:"token"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	ChatID    string     `gorm:"not null;index" json:"chat_id"`
+	MessageID string     `gorm:"index" json:"message_id,omitempty"`
+	ViewCount int        `gorm:"not null;default:0" json:"view_count"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type Workspace struct {
+	ID        string            `gorm:"primaryKey" json:"id"`
+	Name      string            `gorm:"not null" json:"name"`
+	OwnerID   string            `gorm:"not null;index" json:"owner_id"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	DeletedAt gorm.DeletedAt    `gorm:"index" json:"-"`
+	Members   []WorkspaceMember `gorm:"foreignKey:WorkspaceID" json:"members,omitempty"`
+}
+
+type WorkspaceMember struct {
+	WorkspaceID string    `gorm:"primaryKey" json:"workspace_id"`
+	UserID      string    `gorm:"primaryKey;index" json:"user_id"`
+	Role        string    `gorm:"not null" json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type MessageRevision struct {
+	ID           string    `gorm:"primaryKey" json:"id"`
+	MessageID    string    `gorm:"not null;uniqueIndex:idx_message_revision" json:"message_id"`
+	Number       int       `gorm:"not null;uniqueIndex:idx_message_revision" json:"number"`
+	AuthorID     string    `gorm:"index" json:"author_id"`
+	Code         string    `gorm:"type:text" json:"code"`
+	VideoURL     string    `json:"video_url,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	Duration     int       `json:"duration,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type Rating struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	MessageID     string    `gorm:"not null;uniqueIndex:idx_rating_message_user" json:"message_id"`
+	UserID        string    `gorm:"not null;uniqueIndex:idx_rating_message_user" json:"user_id"`
+	Value         int       `gorm:"not null" json:"value"`
+	Reasons       string    `json:"reasons"`
+	Comment       string    `gorm:"type:text" json:"comment,omitempty"`
+	Prompt        string    `gorm:"type:text" json:"prompt"`
+	Model         string    `gorm:"index" json:"model"`
+	PromptVersion string    `gorm:"index" json:"prompt_version"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type PromptTemplate struct {
+	Version          string    `gorm:"primaryKey" json:"version"`
+	System           string    `gorm:"type:text;not null" json:"system"`
+	Explanation      string    `gorm:"type:text;not null" json:"explanation"`
+	Retry            string    `gorm:"type:text" json:"retry"`
+	DurationSimple   string    `gorm:"type:text" json:"duration_simple"`
+	DurationModerate string    `gorm:"type:text" json:"duration_moderate"`
+	DurationComplex  string    `gorm:"type:text" json:"duration_complex"`
+	Notes            string    `json:"notes,omitempty"`
+	IsDefault        bool      `gorm:"index" json:"is_default"`
+	SeededDefault    bool      `gorm:"not null;default:false" json:"seeded_default"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type PromptExperiment struct {
+	ID        string          `gorm:"primaryKey" json:"id"`
+	Name      string          `gorm:"not null" json:"name"`
+	Active    bool            `gorm:"index" json:"active"`
+	StartedAt time.Time       `json:"started_at"`
+	EndedAt   *time.Time      `json:"ended_at,omitempty"`
+	Variants  []PromptVariant `gorm:"foreignKey:ExperimentID" json:"variants"`
+}
+
+type PromptVariant struct {
+	ExperimentID string `gorm:"primaryKey" json:"-"`
+	Version      string `gorm:"primaryKey" json:"version"`
+	Weight       int    `gorm:"not null" json:"weight"`
+}
+
+type GenerationAttempt struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	RunID         string    `gorm:"not null;index" json:"run_id"`
+	MessageID     string    `gorm:"index" json:"message_id,omitempty"`
+	UserID        string    `gorm:"index" json:"user_id"`
+	PromptVersion string    `gorm:"index" json:"prompt_version"`
+	ExperimentID  string    `gorm:"index" json:"experiment_id,omitempty"`
+	Attempt       int       `json:"attempt"`
+	Outcome       string    `gorm:"index" json:"outcome"`
+	Error         string    `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
 }
@@ -0,0 +1,280 @@
+package prompts
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"gorm.io/gorm"
+)
+
+// DefaultVersion is the embedded template set used until another version is
+// made the default, and whenever the database can't be reached.
+const DefaultVersion = "v4"
+
+//go:embed defaults
+var defaults embed.FS
+
+type Vars struct {
+	Prompt        string
+	Complexity    string
+	MinDuration   int
+	MaxDuration   int
+	DurationGuide string
+	StyleGuide    string
+	PreviousError string
+}
+
+// Set is the template version chosen for one generation.
+type Set struct {
+	Version      string
+	ExperimentID string
+	templates    models.PromptTemplate
+}
+
+// embeddedVersions lists the embedded template versions, oldest first.
+func embeddedVersions() ([]string, error) {
+	entries, err := fs.ReadDir(defaults, "defaults")
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			versions = append(versions, entry.Name())
+		}
+	}
+	// v10 sorts after v9.
+	sort.Slice(versions, func(i, j int) bool {
+		if len(versions[i]) != len(versions[j]) {
+			return len(versions[i]) < len(versions[j])
+		}
+		return versions[i] < versions[j]
+	})
+	return versions, nil
+}
+
+// loadDefault reads an embedded template version. A version directory only
+// holds the templates it changes; the rest come from the closest earlier
+// version that has them.
+func loadDefault(version string) (models.PromptTemplate, error) {
+	t := models.PromptTemplate{Version: version}
+
+	versions, err := embeddedVersions()
+	if err != nil {
+		return t, err
+	}
+	latest := slices.Index(versions, version)
+	if latest < 0 {
+		return t, fmt.Errorf("embedded prompt %s does not exist", version)
+	}
+
+	read := func(name string) (string, error) {
+		for i := latest; i >= 0; i-- {
+			b, err := defaults.ReadFile(path.Join("defaults", versions[i], name+".tmpl"))
+			if err == nil {
+				return string(b), nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+		return "", fs.ErrNotExist
+	}
+
+	fields := map[string]*string{
+		"system":            &t.System,
+		"explanation":       &t.Explanation,
+		"retry":             &t.Retry,
+		"duration_simple":   &t.DurationSimple,
+		"duration_moderate": &t.DurationModerate,
+		"duration_complex":  &t.DurationComplex,
+	}
+	for name, field := range fields {
+		body, err := read(name)
+		if err != nil {
+			return t, fmt.Errorf("embedded prompt %s/%s: %w", version, name, err)
+		}
+		*field = body
+	}
+	return t, nil
+}
+
+// Seed stores every embedded template version that isn't in the database yet
+// and makes DefaultVersion the default when nothing else is, or when the
+// current default was itself put there by Seed. A default chosen by an admin
+// is left alone, even if it is an embedded version.
+func Seed() error {
+	versions, err := embeddedVersions()
+	if err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		t, err := loadDefault(version)
+		if err != nil {
+			return err
+		}
+		if err := database.DB.Where("version = ?", t.Version).FirstOrCreate(&t).Error; err != nil {
+			return fmt.Errorf("could not seed prompt %s: %w", t.Version, err)
+		}
+	}
+
+	var current models.PromptTemplate
+	err = database.DB.Where("is_default = ?", true).First(&current).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil && (current.Version == DefaultVersion || !current.SeededDefault) {
+		return nil
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PromptTemplate{}).Where("is_default = ?", true).
+			Updates(map[string]interface{}{"is_default": false, "seeded_default": false}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.PromptTemplate{}).Where("version = ?", DefaultVersion).
+			Updates(map[string]interface{}{"is_default": true, "seeded_default": true}).Error
+	})
+}
+
+// pickVariant buckets a user deterministically so they see the same variant
+// for the whole experiment.
+func pickVariant(experiment models.PromptExperiment, userID string) (string, bool) {
+	total := 0
+	for _, v := range experiment.Variants {
+		total += max(v.Weight, 0)
+	}
+	if total == 0 {
+		return "", false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(experiment.ID + ":" + userID))
+	n := int(h.Sum32() % uint32(total))
+	for _, v := range experiment.Variants {
+		if n < max(v.Weight, 0) {
+			return v.Version, true
+		}
+		n -= max(v.Weight, 0)
+	}
+	return "", false
+}
+
+// Resolve picks the template version for a user's next generation: their
+// variant of the running experiment if there is one, otherwise the default.
+func Resolve(userID string) Set {
+	var experiment models.PromptExperiment
+	err := database.DB.Preload("Variants", func(db *gorm.DB) *gorm.DB {
+		return db.Order("version ASC")
+	}).Where("active = ?", true).Order("started_at DESC").First(&experiment).Error
+	if err == nil {
+		if version, ok := pickVariant(experiment, userID); ok {
+			var t models.PromptTemplate
+			if err := database.DB.Where("version = ?", version).First(&t).Error; err == nil {
+				return Set{Version: t.Version, ExperimentID: experiment.ID, templates: t}
+			}
+			log.Printf("Prompt version %s of experiment %s is missing, using default\n", version, experiment.ID)
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("Could not load prompt experiment:", err)
+	}
+
+	var t models.PromptTemplate
+	if err := database.DB.Where("is_default = ?", true).First(&t).Error; err == nil {
+		return Set{Version: t.Version, templates: t}
+	}
+
+	t, err = loadDefault(DefaultVersion)
+	if err != nil {
+		log.Println("Could not load embedded prompts:", err)
+	}
+	return Set{Version: DefaultVersion, templates: t}
+}
+
+func render(name, body string, vars Vars) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return "", fmt.Errorf("prompt %s: %w", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("prompt %s: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+func durationGuide(t models.PromptTemplate, complexity string) string {
+	switch complexity {
+	case "simple":
+		return t.DurationSimple
+	case "moderate":
+		return t.DurationModerate
+	case "complex":
+		return t.DurationComplex
+	}
+	return ""
+}
+
+// Code builds the prompt for generating a scene, including the retry
+// instructions when a previous attempt failed.
+func (s Set) Code(vars Vars) (string, error) {
+	guide, err := render("duration", durationGuide(s.templates, vars.Complexity), vars)
+	if err != nil {
+		return "", err
+	}
+	vars.DurationGuide = strings.TrimSpace(guide)
+
+	prompt, err := render("system", s.templates.System, vars)
+	if err != nil {
+		return "", err
+	}
+	if vars.PreviousError != "" && s.templates.Retry != "" {
+		retry, err := render("retry", s.templates.Retry, vars)
+		if err != nil {
+			return "", err
+		}
+		prompt = strings.TrimRight(prompt, "\n") + "\n" + retry
+	}
+	return prompt, nil
+}
+
+func (s Set) Explanation(vars Vars) (string, error) {
+	return render("explanation", s.templates.Explanation, vars)
+}
+
+// Validate checks that every template in t parses and renders with sample
+// values, so a broken version can't be stored.
+func Validate(t models.PromptTemplate) error {
+	if strings.TrimSpace(t.System) == "" || strings.TrimSpace(t.Explanation) == "" {
+		return errors.New("system and explanation templates are required")
+	}
+	set := Set{Version: t.Version, templates: t}
+	for _, complexity := range []string{"simple", "moderate", "complex"} {
+		vars := Vars{
+			Prompt:        "Explain the Pythagorean theorem",
+			Complexity:    complexity,
+			MinDuration:   60,
+			MaxDuration:   120,
+			StyleGuide:    "Visual style: sample",
+			PreviousError: "sample error",
+		}
+		if _, err := set.Code(vars); err != nil {
+			return err
+		}
+	}
+	_, err := set.Explanation(Vars{Prompt: "Explain the Pythagorean theorem"})
+	return err
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"gorm.io/gorm"
+)
+
+type RenderOptions struct {
+	Quality string
+	Format  string
+	Scene   string
+	// Style is applied to generated code before rendering, so it is already
+	// reflected in the code hashed by RenderHash.
+	Style string
+	// Untrusted renders run under the sandbox restrictions in sandbox.go.
+	Untrusted bool
+}
+
+var DefaultRenderOptions = RenderOptions{Quality: "low", Format: "mp4", Scene: "Scene"}
+
+var qualityFlags = map[string]string{
+	"low":    "-ql",
+	"medium": "-qm",
+	"high":   "-qh",
+}
+
+func (o RenderOptions) WithDefaults() RenderOptions {
+	if o.Quality == "" {
+		o.Quality = DefaultRenderOptions.Quality
+	}
+	if o.Format == "" {
+		o.Format = DefaultRenderOptions.Format
+	}
+	if o.Scene == "" {
+		o.Scene = DefaultRenderOptions.Scene
+	}
+	return o
+}
+
+// NormalizeCode only strips differences that cannot change the rendered output,
+// so two scenes that normalize to the same text are safe to share a video.
+func NormalizeCode(code string) string {
+	code = strings.ReplaceAll(code, "\r\n", "\n")
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func RenderHash(code string, opts RenderOptions) string {
+	opts = opts.WithDefaults()
+	key := opts.Quality + "\x00" + opts.Format + "\x00" + NormalizeCode(code)
+	// The default scene is left out so hashes from before scenes were
+	// configurable still match.
+	if opts.Scene != DefaultRenderOptions.Scene {
+		key = opts.Scene + "\x00" + key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LookupRender returns a cached render and marks it as just used. The hit is
+// recorded under the same per-object lock DeleteIfUnreferenced takes, so an
+// object can't be deleted between a hit and the caller tracking it.
+func LookupRender(hash string) (models.RenderCache, bool) {
+	var entry models.RenderCache
+	if err := database.DB.Where("hash = ? AND video_url <> ''", hash).First(&entry).Error; err != nil {
+		return entry, false
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockObject(tx, entry.ObjectKey); err != nil {
+			return err
+		}
+		if err := tx.Where("hash = ? AND video_url <> ''", hash).First(&entry).Error; err != nil {
+			return err
+		}
+		return tx.Model(&entry).UpdateColumns(map[string]interface{}{
+			"hit_count":  gorm.Expr("hit_count + 1"),
+			"updated_at": time.Now(),
+		}).Error
+	})
+	return entry, err == nil
+}
+
+func IsStoredURL(path string) bool {
+	return strings.HasPrefix(path, "https://")
+}
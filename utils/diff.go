@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = " "
+	DiffInsert DiffOp = "+"
+	DiffDelete DiffOp = "-"
+)
+
+type DiffLine struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// MaxDiffLines bounds each side of a diff; the LCS table grows with the
+// product of the two line counts.
+const MaxDiffLines = 3000
+
+var ErrDiffTooLarge = fmt.Errorf("texts longer than %d lines cannot be diffed", MaxDiffLines)
+
+// DiffLines computes a line diff from the longest common subsequence of the
+// two texts. Scenes are a few hundred lines at most, so the quadratic table is
+// fine for anything under MaxDiffLines.
+func DiffLines(from, to string) ([]DiffLine, error) {
+	a, b := splitLines(from), splitLines(to)
+	if len(a) > MaxDiffLines || len(b) > MaxDiffLines {
+		return nil, ErrDiffTooLarge
+	}
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, DiffLine{DiffEqual, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{DiffDelete, a[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{DiffInsert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, DiffLine{DiffDelete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, DiffLine{DiffInsert, b[j]})
+	}
+	return lines, nil
+}
+
+// UnifiedDiff renders a diff in the familiar `diff -u` format with the given
+// number of context lines around each change.
+func UnifiedDiff(fromName, toName string, lines []DiffLine, context int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(lines); {
+		for start < len(lines) && lines[start].Op == DiffEqual {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		// Extend the hunk until a run of unchanged lines is long enough to
+		// separate it from the next change.
+		end := start
+		for end < len(lines) {
+			if lines[end].Op != DiffEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].Op == DiffEqual {
+				run++
+			}
+			if run == len(lines) || run-end > 2*context {
+				break
+			}
+			end = run
+		}
+
+		from, to := max(start-context, 0), min(end+context, len(lines))
+
+		oldStart, newStart := 1, 1
+		for _, line := range lines[:from] {
+			if line.Op != DiffInsert {
+				oldStart++
+			}
+			if line.Op != DiffDelete {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, line := range lines[from:to] {
+			if line.Op != DiffInsert {
+				oldCount++
+			}
+			if line.Op != DiffDelete {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, line := range lines[from:to] {
+			b.WriteString(string(line.Op) + line.Text + "\n")
+		}
+		start = to
+	}
+	return b.String()
+}
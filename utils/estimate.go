@@ -0,0 +1,373 @@
+package utils
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DurationEstimate is a static guess at how long a scene plays. Only straight
+// line play and wait calls in construct, and for loops over a constant range,
+// are counted exactly; anything else, such as a helper call, a branch or a
+// run_time that isn't a constant, leaves Exact false.
+type DurationEstimate struct {
+	Seconds float64
+	Exact   bool
+}
+
+// Manim's defaults when run_time or a wait duration isn't given.
+const (
+	defaultPlaySeconds = 1.0
+	defaultWaitSeconds = 1.0
+)
+
+type pyLine struct {
+	indent int
+	text   string
+}
+
+var (
+	classLinePattern  = regexp.MustCompile(`^class\s+(\w+)\s*[(:]`)
+	defLinePattern    = regexp.MustCompile(`^(?:async\s+)?def\s+(\w+)\s*\(`)
+	compoundPattern   = regexp.MustCompile(`^(?:if|elif|else|for|while|with|try|except|finally|match|case|return|yield|async)\b`)
+	rangeLoopPattern  = regexp.MustCompile(`^for\s+(.+?)\s+in\s+range\((.*)\):\s*(.*)$`)
+	assignPattern     = regexp.MustCompile(`^(\w+)\s*=\s*([^=].*)$`)
+	anyAssignPattern  = regexp.MustCompile(`^([\w\s,()\[\]]+?)\s*(?://|\*\*|>>|<<|[-+*/%&|^@])?=[^=]`)
+	selfCallPattern   = regexp.MustCompile(`^self\.(\w+)\s*\(`)
+	bareCallPattern   = regexp.MustCompile(`^(\w+)\s*\(`)
+	selfArgPattern    = regexp.MustCompile(`[(,=]\s*self\s*[,)]`)
+	nestedRunTimeCall = regexp.MustCompile(`\brun_time\s*=`)
+	namePattern       = regexp.MustCompile(`\w+`)
+)
+
+// instantSceneMethods are Scene methods that take no time on their own.
+var instantSceneMethods = map[string]bool{
+	"add": true, "remove": true, "clear": true, "bring_to_front": true, "bring_to_back": true,
+	"add_foreground_mobject": true, "add_foreground_mobjects": true,
+	"remove_foreground_mobject": true, "remove_foreground_mobjects": true,
+	"add_sound": true, "add_subcaption": true, "next_section": true, "set_camera_orientation": true,
+	"add_fixed_in_frame_mobjects": true, "add_fixed_orientation_mobjects": true,
+}
+
+// composedAnimations take their default run time from the animations inside
+// them rather than Manim's one second.
+var composedAnimations = []string{"AnimationGroup(", "LaggedStart(", "LaggedStartMap(", "Succession("}
+
+// logicalLines splits Python source into statements, joining bracketed and
+// backslash continuations and dropping comments and blank lines.
+func logicalLines(code string) []pyLine {
+	var lines []pyLine
+	var current strings.Builder
+	depth, indent, started := 0, 0, false
+	var quote string
+
+	for _, raw := range strings.Split(strings.ReplaceAll(code, "\r\n", "\n"), "\n") {
+		if !started {
+			trimmed := strings.TrimLeft(raw, " \t")
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			indent = len(strings.ReplaceAll(raw[:len(raw)-len(trimmed)], "\t", "    "))
+			raw = trimmed
+			started = true
+		}
+
+		for i := 0; i < len(raw); i++ {
+			ch := raw[i]
+			if quote != "" {
+				current.WriteByte(ch)
+				if ch == '\\' && i+1 < len(raw) {
+					i++
+					current.WriteByte(raw[i])
+				} else if strings.HasPrefix(raw[i:], quote) {
+					current.WriteString(quote[1:])
+					i += len(quote) - 1
+					quote = ""
+				}
+				continue
+			}
+			switch {
+			case ch == '#':
+				i = len(raw)
+				continue
+			case ch == '"' || ch == '\'':
+				quote = string(ch)
+				if strings.HasPrefix(raw[i:], strings.Repeat(string(ch), 3)) {
+					quote = strings.Repeat(string(ch), 3)
+					i += 2
+				}
+				current.WriteString(quote)
+				continue
+			case ch == '(' || ch == '[' || ch == '{':
+				depth++
+			case ch == ')' || ch == ']' || ch == '}':
+				depth--
+			}
+			current.WriteByte(ch)
+		}
+
+		text := strings.TrimSpace(current.String())
+		if quote != "" || depth > 0 {
+			current.WriteByte(' ')
+			continue
+		}
+		if strings.HasSuffix(text, "\\") {
+			current.Reset()
+			current.WriteString(strings.TrimSuffix(text, "\\") + " ")
+			continue
+		}
+		if text != "" {
+			lines = append(lines, pyLine{indent: indent, text: text})
+		}
+		current.Reset()
+		depth, started = 0, false
+	}
+	return lines
+}
+
+// block returns the lines indented under lines[i].
+func block(lines []pyLine, i int) []pyLine {
+	end := i + 1
+	for end < len(lines) && lines[end].indent > lines[i].indent {
+		end++
+	}
+	return lines[i+1 : end]
+}
+
+// callArgs splits the arguments of the call starting at text[open] (an open
+// parenthesis) at top-level commas.
+func callArgs(text string, open int) []string {
+	var args []string
+	depth, start := 0, open+1
+	var quote byte
+	for i := open; i < len(text); i++ {
+		ch := text[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '"', '\'':
+			quote = ch
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				if arg := strings.TrimSpace(text[start:i]); arg != "" {
+					args = append(args, arg)
+				}
+				return args
+			}
+		case ',':
+			if depth == 1 {
+				args = append(args, strings.TrimSpace(text[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return args
+}
+
+func keywordArg(args []string, name string) (string, bool) {
+	for _, arg := range args {
+		if key, value, ok := strings.Cut(arg, "="); ok && strings.TrimSpace(key) == name && !strings.HasPrefix(value, "=") {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
+
+type estimator struct {
+	// vars holds numbers assigned in construct outside any loop.
+	vars  map[string]float64
+	exact bool
+}
+
+// number reads a numeric literal or a variable holding one.
+func (e *estimator) number(expr string) (float64, bool) {
+	expr = strings.TrimSpace(expr)
+	if value, ok := e.vars[expr]; ok {
+		return value, true
+	}
+	value, err := strconv.ParseFloat(expr, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
+
+// iterations counts how many times a loop over range(args) runs.
+func (e *estimator) iterations(args []string) (float64, bool) {
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		value, ok := e.number(arg)
+		if !ok || value != math.Trunc(value) {
+			return 1, false
+		}
+		values[i] = value
+	}
+	start, stop, step := 0.0, 0.0, 1.0
+	switch len(values) {
+	case 1:
+		stop = values[0]
+	case 2:
+		start, stop = values[0], values[1]
+	case 3:
+		start, stop, step = values[0], values[1], values[2]
+	default:
+		return 1, false
+	}
+	if step == 0 {
+		return 1, false
+	}
+	return math.Max(0, math.Ceil((stop-start)/step)), true
+}
+
+func (e *estimator) playSeconds(text string, args []string) float64 {
+	value, ok := keywordArg(args, "run_time")
+	if !ok {
+		for _, arg := range args {
+			if nestedRunTimeCall.MatchString(arg) {
+				e.exact = false
+			}
+		}
+		for _, name := range composedAnimations {
+			if strings.Contains(text, name) {
+				e.exact = false
+			}
+		}
+		return defaultPlaySeconds
+	}
+	seconds, ok := e.number(value)
+	if !ok {
+		e.exact = false
+		return defaultPlaySeconds
+	}
+	return seconds
+}
+
+func (e *estimator) waitSeconds(args []string) float64 {
+	value, ok := keywordArg(args, "duration")
+	if !ok && len(args) > 0 && !strings.Contains(args[0], "=") {
+		value, ok = args[0], true
+	}
+	if !ok {
+		return defaultWaitSeconds
+	}
+	seconds, parsed := e.number(value)
+	if !parsed {
+		e.exact = false
+		return defaultWaitSeconds
+	}
+	return seconds
+}
+
+// forget drops the variables a statement assigns, if any.
+func (e *estimator) forget(text string) {
+	if m := anyAssignPattern.FindStringSubmatch(text); m != nil {
+		for _, name := range namePattern.FindAllString(m[1], -1) {
+			delete(e.vars, name)
+		}
+	}
+}
+
+func (e *estimator) lines(lines []pyLine, inLoop bool) float64 {
+	total := 0.0
+	for i := 0; i < len(lines); i++ {
+		text := lines[i].text
+
+		if defLinePattern.MatchString(text) || classLinePattern.MatchString(text) {
+			i += len(block(lines, i))
+			continue
+		}
+
+		if m := rangeLoopPattern.FindStringSubmatch(text); m != nil {
+			count, ok := e.iterations(callArgs("("+m[2]+")", 0))
+			if !ok {
+				e.exact = false
+			}
+			body := block(lines, i)
+			if m[3] != "" {
+				body = []pyLine{{indent: lines[i].indent + 1, text: m[3]}}
+			}
+			// The body is counted once and multiplied, so nothing it assigns
+			// can be trusted as a constant, before or after the loop.
+			for _, name := range namePattern.FindAllString(m[1], -1) {
+				delete(e.vars, name)
+			}
+			for _, line := range body {
+				e.forget(line.text)
+			}
+			total += count * e.lines(body, true)
+			i += len(block(lines, i))
+			continue
+		}
+
+		if compoundPattern.MatchString(text) {
+			e.exact = false
+			i += len(block(lines, i))
+			continue
+		}
+
+		if m := assignPattern.FindStringSubmatch(text); m != nil && !inLoop {
+			if value, err := strconv.ParseFloat(strings.TrimSpace(m[2]), 64); err == nil {
+				e.vars[m[1]] = value
+				continue
+			}
+		}
+		e.forget(text)
+
+		if m := selfCallPattern.FindStringSubmatchIndex(text); m != nil {
+			name := text[m[2]:m[3]]
+			args := callArgs(text, m[1]-1)
+			switch {
+			case name == "play":
+				total += e.playSeconds(text, args)
+			case name == "wait":
+				total += e.waitSeconds(args)
+			case !instantSceneMethods[name]:
+				e.exact = false
+			}
+			continue
+		}
+
+		// A helper function may play animations of its own.
+		if m := bareCallPattern.FindStringSubmatch(text); (m != nil && m[1] != "print") || selfArgPattern.MatchString(text) {
+			e.exact = false
+		}
+	}
+	return total
+}
+
+// EstimateDuration adds up the play and wait calls in the scene's construct
+// method without running it, so obviously short or long scenes can be
+// repaired before an expensive render.
+func EstimateDuration(code, scene string) DurationEstimate {
+	if scene == "" {
+		scene = DefaultRenderOptions.Scene
+	}
+	lines := logicalLines(code)
+
+	var class []pyLine
+	for i, line := range lines {
+		if m := classLinePattern.FindStringSubmatch(line.text); m != nil && m[1] == scene {
+			class = block(lines, i)
+			break
+		}
+	}
+
+	for i, line := range class {
+		if m := defLinePattern.FindStringSubmatch(line.text); m != nil && m[1] == "construct" && line.indent == class[0].indent {
+			e := &estimator{vars: map[string]float64{}, exact: true}
+			seconds := e.lines(block(class, i), false)
+			return DurationEstimate{Seconds: seconds, Exact: e.exact}
+		}
+	}
+	return DurationEstimate{}
+}
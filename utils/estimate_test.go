@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEstimateDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    string
+		seconds float64
+		exact   bool
+	}{
+		{
+			name: "plays and waits",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        c = Circle()
+        self.add(c)
+        self.play(Create(c))
+        self.play(FadeOut(c), run_time=2.5)
+        self.wait(2)
+        self.wait()
+`,
+			seconds: 6.5,
+			exact:   true,
+		},
+		{
+			name: "constant variables",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        rt = 2
+        pause = 6
+        self.play(Write(t), run_time=rt)
+        self.wait(duration=pause)
+`,
+			seconds: 8,
+			exact:   true,
+		},
+		{
+			name: "constant range loop",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        for _ in range(3):
+            dot = Dot()
+            self.play(FadeIn(dot), run_time=2)
+        for i in range(2, 4): self.wait(0.5)
+`,
+			seconds: 7,
+			exact:   true,
+		},
+		{
+			name: "run_time inside the animation",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        self.play(Write(t, run_time=30))
+        self.wait(30)
+`,
+			seconds: 31,
+			exact:   false,
+		},
+		{
+			name: "composed animation without run_time",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        self.play(LaggedStart(*[FadeIn(d) for d in dots]))
+`,
+			seconds: 1,
+			exact:   false,
+		},
+		{
+			name: "variable changed in the loop",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        t = 1
+        for i in range(10):
+            self.wait(t)
+            t += 5
+`,
+			seconds: 10,
+			exact:   false,
+		},
+		{
+			name: "loop variable",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        for i in range(1, 4):
+            self.wait(i)
+`,
+			seconds: 3,
+			exact:   false,
+		},
+		{
+			name: "computed value",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        rt = 2
+        self.wait(rt * 3)
+`,
+			seconds: 1,
+			exact:   false,
+		},
+		{
+			name: "helper method",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        self.intro()
+        self.wait(2)
+
+    def intro(self):
+        self.wait(40)
+`,
+			seconds: 2,
+			exact:   false,
+		},
+		{
+			name: "function given the scene",
+			code: `
+def intro(scene):
+    scene.play(Write(title), run_time=10)
+
+class Scene(Scene):
+    def construct(self):
+        helpers.intro(self)
+`,
+			seconds: 0,
+			exact:   false,
+		},
+		{
+			name: "super call",
+			code: `
+class Scene(Base):
+    def construct(self):
+        super().construct()
+        self.wait(5)
+`,
+			seconds: 5,
+			exact:   false,
+		},
+		{
+			name: "branch",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        if show_intro:
+            self.wait(10)
+        self.wait(5)
+`,
+			seconds: 5,
+			exact:   false,
+		},
+		{
+			name: "loop over a list",
+			code: `
+class Scene(Scene):
+    def construct(self):
+        for dot in dots:
+            self.play(FadeIn(dot))
+`,
+			seconds: 0,
+			exact:   false,
+		},
+		{
+			name: "missing construct",
+			code: `
+class Other(Scene):
+    def construct(self):
+        self.wait(5)
+`,
+			seconds: 0,
+			exact:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EstimateDuration(strings.TrimPrefix(tt.code, "\n"), "Scene")
+			if math.Abs(got.Seconds-tt.seconds) > 1e-9 || got.Exact != tt.exact {
+				t.Fatalf("EstimateDuration = {%v %v}, want {%v %v}", got.Seconds, got.Exact, tt.seconds, tt.exact)
+			}
+		})
+	}
+}
+
+func TestEstimateDurationLargeLoop(t *testing.T) {
+	code := `class Scene(Scene):
+    def construct(self):
+        for i in range(1000000):
+            self.wait(0.5)
+`
+	got := EstimateDuration(code, "Scene")
+	if got.Seconds != 500000 || !got.Exact {
+		t.Fatalf("EstimateDuration = %+v, want 500000 exact", got)
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+)
+
+const maxPreflightOutput = 3000
+
+func preflightTimeout() time.Duration {
+	if seconds, err := strconv.Atoi(os.Getenv("PREFLIGHT_TIMEOUT_SECONDS")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 2 * time.Minute
+}
+
+func tailOutput(output []byte) string {
+	if len(output) > maxPreflightOutput {
+		output = output[len(output)-maxPreflightOutput:]
+	}
+	return string(output)
+}
+
+func cpuSeconds(cmd *exec.Cmd) float64 {
+	if cmd.ProcessState == nil {
+		return 0
+	}
+	return (cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()).Seconds()
+}
+
+// Preflight checks that a scene compiles and that construct() runs, using
+// Manim's --dry_run so nothing is encoded. It is skipped when the render is
+// already cached or RENDER_PREFLIGHT=false. It returns the CPU time spent so
+// callers can bill it like a render.
+func Preflight(ctx context.Context, code string, opts RenderOptions) (float64, error) {
+	opts = opts.WithDefaults()
+	if os.Getenv("RENDER_PREFLIGHT") == "false" {
+		return 0, nil
+	}
+
+	var cached int64
+	database.DB.Model(&models.RenderCache{}).Where("hash = ? AND video_url <> ''", RenderHash(code, opts)).Count(&cached)
+	if cached > 0 {
+		return 0, nil
+	}
+
+	tempDir, err := os.MkdirTemp("", "manim-preflight-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	tempFile := filepath.Join(tempDir, "animation.py")
+	if err := os.WriteFile(tempFile, []byte(code), 0644); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout())
+	defer cancel()
+
+	compile := exec.CommandContext(ctx, "python3", "-m", "py_compile", tempFile)
+	output, err := compile.CombinedOutput()
+	spent := cpuSeconds(compile)
+	if err != nil {
+		return spent, fmt.Errorf("syntax check failed: %v\nOutput: %s", err, tailOutput(output))
+	}
+
+	args := []string{
+		"--dry_run",
+		"-ql",
+		"--media_dir", filepath.Join(tempDir, "media"),
+		tempFile,
+		opts.Scene,
+	}
+	var cmd *exec.Cmd
+	if opts.Untrusted {
+		if cmd, err = sandboxCommand(ctx, tempDir, args); err != nil {
+			return spent, err
+		}
+	} else {
+		cmd = exec.CommandContext(ctx, "manim", args...)
+	}
+
+	output, err = cmd.CombinedOutput()
+	spent += cpuSeconds(cmd)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return spent, fmt.Errorf("dry run timed out after %s", preflightTimeout())
+	}
+	if err != nil {
+		return spent, fmt.Errorf("dry run failed: %v\nOutput: %s", err, tailOutput(output))
+	}
+	return spent, nil
+}
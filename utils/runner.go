@@ -1,61 +1,110 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
+
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
 )
 
-func RunCode(code string) (string, int, error) {
+type RenderResult struct {
+	Path         string
+	ThumbnailURL string
+	Duration     int
+	CPUSeconds   float64
+}
+
+func RunCode(ctx context.Context, code string, opts RenderOptions) (RenderResult, error) {
+	var result RenderResult
+
+	opts = opts.WithDefaults()
+	qualityFlag, ok := qualityFlags[opts.Quality]
+	if !ok {
+		return result, fmt.Errorf("unsupported quality: %s", opts.Quality)
+	}
+
+	hash := RenderHash(code, opts)
+	if cached, ok := LookupRender(hash); ok {
+		fmt.Printf("render cache hit for %s\n", hash)
+		result.Path, result.Duration, result.ThumbnailURL = cached.VideoURL, cached.Duration, cached.ThumbnailURL
+		return result, nil
+	}
+
 	tempDir, err := os.MkdirTemp("", "manim-")
 	if err != nil {
-		return "", 0, err
+		return result, err
 	}
 	defer os.RemoveAll(tempDir)
 
 	tempFile := filepath.Join(tempDir, "animation.py")
 	if err := os.WriteFile(tempFile, []byte(code), 0644); err != nil {
-		return "", 0, err
+		return result, err
 	}
 
-	outputFile := fmt.Sprintf("animation_%d.mp4", time.Now().Unix())
+	outputFile := hash + "." + opts.Format
 
-	cmd := exec.Command(
-		"manim",
-		"-ql",
-		"--media_dir", "static",
+	// Manim writes partial movies and caches next to the video, so every render
+	// gets its own media directory and only the finished video is moved into
+	// static. The sandbox may only write to its scratch directory anyway.
+	dir, _ := os.Getwd()
+	staticDir := filepath.Join(dir, "static")
+	mediaDir := filepath.Join(tempDir, "media")
+	args := []string{
+		qualityFlag,
+		"--format", opts.Format,
+		"--media_dir", mediaDir,
 		tempFile,
-		"Scene",
+		opts.Scene,
 		"-o", outputFile,
-	)
+	}
+
+	var cmd *exec.Cmd
+	if opts.Untrusted {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sandboxTimeout())
+		defer cancel()
+		if cmd, err = sandboxCommand(ctx, tempDir, args); err != nil {
+			return result, err
+		}
+	} else {
+		cmd = exec.CommandContext(ctx, "manim", args...)
+	}
 
 	output, err := cmd.CombinedOutput()
+	result.CPUSeconds = cpuSeconds(cmd)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return result, fmt.Errorf("render timed out after %s", sandboxTimeout())
+	}
 	if err != nil {
-		return "", 0, fmt.Errorf("manim execution failed: %v\nOutput: %s", err, string(output))
+		return result, fmt.Errorf("manim execution failed: %v\nOutput: %s", err, string(output))
 	}
 
-	dir, _ := os.Getwd()
-	staticDir := filepath.Join(dir, "static", "videos", "animation")
-
 	var videoFullPath string
 	var videoRelativePath string
 
 	qualityDirs := []string{"480p15", "720p30", "1080p60"}
 	for _, quality := range qualityDirs {
-		possiblePath := filepath.Join(staticDir, quality, outputFile)
+		possiblePath := filepath.Join(mediaDir, "videos", "animation", quality, outputFile)
 		if _, err := os.Stat(possiblePath); err == nil {
-			videoFullPath = possiblePath
+			videoFullPath = filepath.Join(staticDir, "videos", "animation", quality, outputFile)
 			videoRelativePath = "/static/videos/animation/" + quality + "/" + outputFile
+			if err := moveFile(possiblePath, videoFullPath); err != nil {
+				return result, fmt.Errorf("could not move rendered video: %v", err)
+			}
 			break
 		}
 	}
 
 	if videoFullPath == "" {
-		return "", 0, fmt.Errorf("could not find generated video file")
+		return result, fmt.Errorf("could not find generated video file")
 	}
 
 	duration, err := GetVideoDuration(videoFullPath)
@@ -64,7 +113,46 @@ func RunCode(code string) (string, int, error) {
 		duration = 60
 	}
 
-	return videoRelativePath, duration, nil
+	entry := models.RenderCache{
+		Hash:      hash,
+		ObjectKey: outputFile,
+		Quality:   opts.Quality,
+		Format:    opts.Format,
+		Duration:  duration,
+	}
+	if err := database.DB.Save(&entry).Error; err != nil {
+		fmt.Printf("Warning: failed to record render cache entry: %v\n", err)
+	}
+
+	result.Path, result.Duration = videoRelativePath, duration
+	return result, nil
+}
+
+// moveFile renames src to dst, copying when they are on different filesystems.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
 }
 
 func GetVideoDuration(videoPath string) (int, error) {
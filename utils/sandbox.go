@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxUserCodeBytes = 64 * 1024
+
+var (
+	sceneNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	importPattern    = regexp.MustCompile(`(?m)(?:^|;)\s*(?:from\s+([A-Za-z_][\w.]*)\s+import|import\s+([^\n#;]+))`)
+	forbiddenPattern = regexp.MustCompile(`\b(__import__|eval|exec|compile|open|globals|locals|vars|getattr|setattr|delattr|breakpoint|input)\s*\(|__(builtins|subclasses|globals|code|loader)__`)
+)
+
+// allowedModules are the only top-level modules user-supplied scenes may import.
+var allowedModules = map[string]bool{
+	"manim":       true,
+	"numpy":       true,
+	"math":        true,
+	"random":      true,
+	"colour":      true,
+	"itertools":   true,
+	"functools":   true,
+	"collections": true,
+	"typing":      true,
+	"dataclasses": true,
+	"enum":        true,
+	"fractions":   true,
+	"decimal":     true,
+	"statistics":  true,
+	"cmath":       true,
+	"string":      true,
+	"scipy":       true,
+	"sympy":       true,
+	"__future__":  true,
+}
+
+// ValidateUserCode gives early, readable errors for scenes that are malformed
+// or plainly reach outside the renderer. It is not a security boundary: Python
+// has endless ways around a pattern list (random._os.system, typing.sys,
+// collections._sys, ...). Untrusted code is only ever run inside the wrapper
+// checked by SandboxAvailable.
+func ValidateUserCode(code, scene string) error {
+	if len(code) > maxUserCodeBytes {
+		return fmt.Errorf("code exceeds %d KB", maxUserCodeBytes/1024)
+	}
+	if !sceneNamePattern.MatchString(scene) {
+		return fmt.Errorf("invalid scene class name %q", scene)
+	}
+	if !regexp.MustCompile(`(?m)^class\s+` + scene + `\s*\(`).MatchString(code) {
+		return fmt.Errorf("scene class %q not found", scene)
+	}
+
+	for _, match := range importPattern.FindAllStringSubmatch(code, -1) {
+		modules := match[1]
+		if modules == "" {
+			modules = match[2]
+		}
+		for _, module := range strings.Split(modules, ",") {
+			fields := strings.Fields(module)
+			if len(fields) == 0 {
+				continue
+			}
+			root := strings.SplitN(strings.Trim(fields[0], "()\\"), ".", 2)[0]
+			if !allowedModules[root] {
+				return fmt.Errorf("import of %q is not allowed", root)
+			}
+		}
+	}
+
+	if match := forbiddenPattern.FindString(code); match != "" {
+		return fmt.Errorf("use of %q is not allowed", strings.TrimRight(match, " \t("))
+	}
+	return nil
+}
+
+func sandboxTimeout() time.Duration {
+	if seconds, err := strconv.Atoi(os.Getenv("RENDER_SANDBOX_TIMEOUT_SECONDS")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// isolatingWrappers are the launchers accepted in RENDER_SANDBOX_WRAPPER, with
+// the flags that cut the scene off from the network. Running as an
+// unprivileged user with a read-only filesystem is left to the wrapper's
+// profile, e.g. "nsjail --quiet --user 65534 --group 65534 -R /usr -R /lib
+// -B /tmp --" or "firejail --quiet --net=none --read-only=/ --read-write=/tmp".
+var isolatingWrappers = map[string][]string{
+	"nsjail":   nil, // isolates the network unless told otherwise
+	"firejail": {"--net=none"},
+	"bwrap":    {"--unshare-net", "--unshare-all"},
+	"docker":   {"--network=none", "--network none", "--net=none", "--net none"},
+	"podman":   {"--network=none", "--network none", "--net=none", "--net none"},
+	"runsc":    {"--network=none", "--network none"},
+}
+
+func sandboxWrapper() ([]string, error) {
+	wrapper := strings.Fields(os.Getenv("RENDER_SANDBOX_WRAPPER"))
+	if len(wrapper) == 0 {
+		return nil, errors.New("RENDER_SANDBOX_WRAPPER is not set")
+	}
+
+	name := filepath.Base(wrapper[0])
+	noNetwork, ok := isolatingWrappers[name]
+	if !ok {
+		return nil, fmt.Errorf("RENDER_SANDBOX_WRAPPER uses %q, which is not a supported isolation tool", name)
+	}
+
+	flags := strings.Join(wrapper[1:], " ")
+	if name == "nsjail" {
+		if strings.Contains(flags, "--disable_clone_newnet") || slices.Contains(wrapper[1:], "-N") {
+			return nil, errors.New("RENDER_SANDBOX_WRAPPER must not give nsjail network access")
+		}
+	} else if !slices.ContainsFunc(noNetwork, func(flag string) bool { return strings.Contains(flags, flag) }) {
+		return nil, fmt.Errorf("RENDER_SANDBOX_WRAPPER must disable networking with %s", noNetwork[0])
+	}
+
+	if _, err := exec.LookPath(wrapper[0]); err != nil {
+		return nil, fmt.Errorf("RENDER_SANDBOX_WRAPPER: %v", err)
+	}
+	return wrapper, nil
+}
+
+// SandboxAvailable returns why untrusted code can't be rendered on this
+// server, or nil if an isolating wrapper is configured.
+func SandboxAvailable() error {
+	_, err := sandboxWrapper()
+	return err
+}
+
+// sandboxCommand builds the manim invocation for untrusted code inside the
+// configured wrapper. It is killed when ctx expires and runs in a scratch
+// directory with an environment stripped of credentials. Without a wrapper it
+// refuses to run at all.
+func sandboxCommand(ctx context.Context, workDir string, args []string) (*exec.Cmd, error) {
+	wrapper, err := sandboxWrapper()
+	if err != nil {
+		return nil, fmt.Errorf("untrusted code cannot run without a sandbox: %w", err)
+	}
+	args = append(append(wrapper[1:], "manim"), args...)
+
+	cmd := exec.CommandContext(ctx, wrapper[0], args...)
+	cmd.Dir = workDir
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + workDir,
+		"TMPDIR=" + workDir,
+		"PYTHONDONTWRITEBYTECODE=1",
+		"PYTHONNOUSERSITE=1",
+	}
+	cmd.WaitDelay = 5 * time.Second
+	return cmd, nil
+}
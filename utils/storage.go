@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	s3Bucket = "feynman-bot"
+	s3Region = "ap-south-1"
+)
+
+var s3BaseURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", s3Bucket, s3Region)
+
+type BucketObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+func newS3Client() (*s3.S3, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(s3Region),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not start session, %v", err)
+	}
+	return s3.New(sess), nil
+}
+
+func objectURL(key string) string {
+	return s3BaseURL + key
+}
+
+func ObjectKeyFromURL(url string) (string, bool) {
+	if !strings.HasPrefix(url, s3BaseURL) {
+		return "", false
+	}
+	key := strings.TrimPrefix(url, s3BaseURL)
+	return key, key != ""
+}
+
+func TrackObject(messageID, url string, size int64) {
+	key, ok := ObjectKeyFromURL(url)
+	if !ok {
+		return
+	}
+
+	object := models.StoredObject{MessageID: messageID, ObjectKey: key, Size: size}
+	if err := database.DB.Create(&object).Error; err != nil {
+		fmt.Printf("Warning: failed to track stored object %s: %v\n", key, err)
+	}
+}
+
+func DeleteFromS3(key string) error {
+	svc, err := newS3Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s3Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s from s3: %v", key, err)
+	}
+	return nil
+}
+
+// DropMessageObjects removes the messages' references inside tx and returns
+// the keys they pointed at. Once tx commits, pass the keys to
+// DeleteUnreferencedObjects; deleting earlier would lose objects whose
+// messages survive a rolled back purge.
+func DropMessageObjects(tx *gorm.DB, messageIDs []string) ([]string, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	var keys []string
+	if err := tx.Model(&models.StoredObject{}).Where("message_id IN ?", messageIDs).Distinct().Pluck("object_key", &keys).Error; err != nil {
+		return nil, fmt.Errorf("could not list stored objects: %w", err)
+	}
+
+	if err := tx.Where("message_id IN ?", messageIDs).Delete(&models.StoredObject{}).Error; err != nil {
+		return nil, fmt.Errorf("could not release stored objects: %w", err)
+	}
+	return keys, nil
+}
+
+// DeleteUnreferencedObjects deletes each object nothing points at anymore.
+// Renders are content-addressed and shared between messages, so an object is
+// only removed with its last reference.
+func DeleteUnreferencedObjects(keys []string) {
+	for _, key := range keys {
+		if _, err := DeleteIfUnreferenced(key); err != nil {
+			fmt.Println("Warning:", err)
+		}
+	}
+}
+
+// A render served from the cache is tracked by its new message shortly after
+// the hit; until then the object is kept even with no references. Anything
+// never picked up is collected by the orphan sweeper.
+const recentHitGrace = time.Hour
+
+func lockObject(tx *gorm.DB, key string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "object:"+key).Error
+}
+
+// DeleteIfUnreferenced removes an object and its cache entries once nothing
+// references it. It reports whether the object was deleted.
+func DeleteIfUnreferenced(key string) (bool, error) {
+	deletable := false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockObject(tx, key); err != nil {
+			return err
+		}
+
+		var references int64
+		if err := tx.Model(&models.StoredObject{}).Where("object_key = ?", key).Count(&references).Error; err != nil {
+			return fmt.Errorf("could not count references to %s: %v", key, err)
+		}
+		if references > 0 {
+			return nil
+		}
+
+		// Cache entries point at the object either as their video or as the
+		// thumbnail of one.
+		var recentHits int64
+		if err := tx.Model(&models.RenderCache{}).
+			Where("(object_key = ? OR thumbnail_url = ?) AND updated_at > ?", key, objectURL(key), time.Now().Add(-recentHitGrace)).
+			Count(&recentHits).Error; err != nil {
+			return fmt.Errorf("could not check render cache for %s: %v", key, err)
+		}
+		if recentHits > 0 {
+			return nil
+		}
+
+		// Once the cache entry is gone no new render can pick up the object.
+		// A cached video keeps its entry and is served without a thumbnail.
+		if err := tx.Where("object_key = ?", key).Delete(&models.RenderCache{}).Error; err != nil {
+			return fmt.Errorf("could not clear render cache for %s: %v", key, err)
+		}
+		if err := tx.Model(&models.RenderCache{}).Where("thumbnail_url = ?", objectURL(key)).Update("thumbnail_url", "").Error; err != nil {
+			return fmt.Errorf("could not clear cached thumbnail %s: %v", key, err)
+		}
+		deletable = true
+		return nil
+	})
+	if err != nil || !deletable {
+		return false, err
+	}
+	return true, DeleteFromS3(key)
+}
+
+func ListBucketObjects() ([]BucketObject, error) {
+	svc, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []BucketObject
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(s3Bucket)}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, BucketObject{
+				Key:          aws.StringValue(object.Key),
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bucket: %v", err)
+	}
+	return objects, nil
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const DefaultStyle = "3b1b"
+
+type StylePreset struct {
+	Name       string   `json:"name"`
+	Label      string   `json:"label"`
+	Background string   `json:"background"`
+	Foreground string   `json:"foreground"`
+	Font       string   `json:"font,omitempty"`
+	Palette    []string `json:"palette"`
+	Guidance   string   `json:"-"`
+}
+
+var stylePresets = map[string]StylePreset{
+	"3b1b": {
+		Name:       "3b1b",
+		Label:      "3Blue1Brown dark",
+		Background: "#000000",
+		Foreground: "#FFFFFF",
+		Palette:    []string{"#58C4DD", "#FFFF00", "#83C167", "#FC6255", "#9A72AC"},
+		Guidance: `Visual style: 3Blue1Brown.
+- Dark background with bright, saturated accents (BLUE, YELLOW, GREEN for emphasis)
+- Smooth transformations with Transform, ReplacementTransform and FadeTransform
+- Build complexity gradually, introducing one element at a time
+- Use Tex()/MathTex() for mathematics and Write() or FadeIn() for text over 1-2 seconds`,
+	},
+	"whiteboard": {
+		Name:       "whiteboard",
+		Label:      "Minimal whiteboard",
+		Background: "#FFFFFF",
+		Foreground: "#1A1A1A",
+		Palette:    []string{"#1F4E79", "#C0392B", "#27AE60", "#7F8C8D"},
+		Guidance: `Visual style: minimal whiteboard.
+- Light background, so NEVER use WHITE or very light colors for text or shapes
+- Dark ink for text and outlines; use at most two accent colors
+- Thin strokes, lots of whitespace, few elements on screen at once
+- Prefer Create() and Write() so diagrams look hand-drawn step by step`,
+	},
+	"chalkboard": {
+		Name:       "chalkboard",
+		Label:      "Chalkboard",
+		Background: "#1E3B2F",
+		Foreground: "#F5F5EB",
+		Palette:    []string{"#F5F5EB", "#F7E463", "#8FD3FE", "#F28B82"},
+		Guidance: `Visual style: classroom chalkboard.
+- Green board background with off-white "chalk" text and pastel accents
+- Write equations line by line with Write() as a teacher would
+- Keep diagrams simple and hand-drawn looking; avoid filled shapes and gradients`,
+	},
+	"high-contrast": {
+		Name:       "high-contrast",
+		Label:      "High contrast (accessible)",
+		Background: "#000000",
+		Foreground: "#FFFFFF",
+		Palette:    []string{"#FFD700", "#00BFFF", "#FF8C00", "#FFFFFF"},
+		Guidance: `Visual style: high contrast and accessible.
+- Only use the palette colors, which stay distinguishable with color blindness
+- Never rely on color alone: also label or shape-code anything that differs
+- Use large text (font_size 36 or more) and thick strokes (stroke_width 6 or more)
+- Slower pacing: run_time of at least 1.5 seconds and longer waits after key points`,
+	},
+	"brand": {
+		Name:  "brand",
+		Label: "Brand theme",
+		Guidance: `Visual style: brand theme.
+- Use only the brand palette colors for text, shapes and highlights
+- Keep layouts clean and consistent, with the title in the primary brand color`,
+	},
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// LookupStyle returns a preset by name. The brand theme is filled in from the
+// BRAND_* environment variables so deployments can set their own colors.
+func LookupStyle(name string) (StylePreset, bool) {
+	if name == "" {
+		name = DefaultStyle
+	}
+	preset, ok := stylePresets[name]
+	if !ok {
+		return preset, false
+	}
+	if name == "brand" {
+		preset.Background = envOr("BRAND_BACKGROUND", "#0B1021")
+		preset.Foreground = envOr("BRAND_FOREGROUND", "#FFFFFF")
+		preset.Font = os.Getenv("BRAND_FONT")
+		preset.Palette = strings.Split(envOr("BRAND_PALETTE", "#6C63FF,#00D1B2,#FFB703"), ",")
+		for i, color := range preset.Palette {
+			preset.Palette[i] = strings.TrimSpace(color)
+		}
+	}
+	return preset, true
+}
+
+func IsStyle(name string) bool {
+	_, ok := stylePresets[name]
+	return ok
+}
+
+func StylePresets() []StylePreset {
+	presets := make([]StylePreset, 0, len(stylePresets))
+	for name := range stylePresets {
+		preset, _ := LookupStyle(name)
+		presets = append(presets, preset)
+	}
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+	return presets
+}
+
+// PromptGuide is the style section injected into the code generation prompt.
+func (s StylePreset) PromptGuide() string {
+	return fmt.Sprintf("%s\n- Background is %s and default text/stroke color is %s (already configured, do not change them)\n- Palette: %s",
+		s.Guidance, s.Background, s.Foreground, strings.Join(s.Palette, ", "))
+}
+
+// ApplyStyle appends the preset's Manim configuration to a scene. Module level
+// code runs before the scene renders, so these settings win over anything the
+// scene set at import time.
+func ApplyStyle(code string, style StylePreset) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(code, "\n"))
+	fmt.Fprintf(&b, "\n\n# style: %s\n", style.Name)
+	fmt.Fprintf(&b, "config.background_color = %q\n", style.Background)
+	for _, cls := range []string{"Text", "MarkupText", "Tex", "MathTex"} {
+		if style.Font != "" && strings.HasSuffix(cls, "Text") {
+			fmt.Fprintf(&b, "%s.set_default(color=%q, font=%q)\n", cls, style.Foreground, style.Font)
+			continue
+		}
+		fmt.Fprintf(&b, "%s.set_default(color=%q)\n", cls, style.Foreground)
+	}
+	fmt.Fprintf(&b, "VMobject.set_default(color=%q)\n", style.Foreground)
+	return b.String()
+}
@@ -2,54 +2,152 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	database "github.com/tabishnaqvi1311/manimbot-backend/db"
+	"github.com/tabishnaqvi1311/manimbot-backend/models"
 )
 
-func UploadToS3(filePath string) (string, error) {
+var contentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mov":  "video/quicktime",
+	".gif":  "image/gif",
+	".jpg":  "image/jpeg",
+}
+
+// RemoveLocalRender deletes a finished render that will not be uploaded.
+// Renders served from the cache are already stored and are left alone.
+func RemoveLocalRender(path string) {
+	if path == "" || IsStoredURL(path) {
+		return
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	if err := os.Remove(filepath.Join(dir, path)); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: could not remove render %s: %v\n", path, err)
+	}
+}
+
+func UploadToS3(filePath string) (string, int64, error) {
+	if IsStoredURL(filePath) {
+		return filePath, 0, nil
+	}
+
 	dir, err := os.Getwd()
 	if err != nil {
-		return "", fmt.Errorf("could not find dir, %v", err)
+		return "", 0, fmt.Errorf("could not find dir, %v", err)
 	}
 
 	fullPath := filepath.Join(dir, filePath)
 	file, err := os.Open(fullPath)
 	if err != nil {
-		return "", fmt.Errorf("could not open file at %s: %v", fullPath, err)
+		return "", 0, fmt.Errorf("could not open file at %s: %v", fullPath, err)
 	}
 	defer file.Close()
 
 	objectKey := filepath.Base(filePath)
+	contentType, ok := contentTypes[strings.ToLower(filepath.Ext(objectKey))]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String("ap-south-1"),
-	})
-	if err != nil {
-		return "", fmt.Errorf("could not start session, %v", err)
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	if err := putObject(file, objectKey, contentType); err != nil {
+		return "", 0, err
+	}
+
+	file.Close()
+
+	// Other renders may still be writing or uploading their own files under
+	// static, so only this one is removed.
+	if err := os.Remove(fullPath); err != nil {
+		fmt.Printf("Warning: failed to delete local file: %v\n", err)
 	}
 
-	svc := s3.New(sess)
+	url := objectURL(objectKey)
+	if err := database.DB.Model(&models.RenderCache{}).Where("object_key = ?", objectKey).
+		Updates(map[string]interface{}{"video_url": url, "size": size}).Error; err != nil {
+		fmt.Printf("Warning: failed to update render cache entry: %v\n", err)
+	}
+
+	return url, size, nil
+}
+
+func putObject(body io.ReadSeeker, key, contentType string) error {
+	svc, err := newS3Client()
+	if err != nil {
+		return err
+	}
 
 	_, err = svc.PutObject(&s3.PutObjectInput{
-		Bucket:      aws.String("feynman-bot"),
-		Key:         aws.String(objectKey),
-		Body:        file,
-		ContentType: aws.String("video/mp4"),
+		Bucket:      aws.String(s3Bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to upload file to s3: %v", err)
+		return fmt.Errorf("failed to upload file to s3: %v", err)
 	}
+	return nil
+}
 
-	file.Close()
+// UploadThumbnail must run before UploadToS3, which removes the local video.
+func UploadThumbnail(videoPath string, duration int) (string, int64, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", 0, fmt.Errorf("could not find dir, %v", err)
+	}
 
-	staticDir := filepath.Join(dir, "static")
-	if err := os.RemoveAll(staticDir); err != nil {
-		fmt.Printf("Warning: failed to delete local file: %v\n", err)
+	tempDir, err := os.MkdirTemp("", "thumb-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	videoKey := filepath.Base(videoPath)
+	objectKey := strings.TrimSuffix(videoKey, filepath.Ext(videoKey)) + ".jpg"
+	thumbPath := filepath.Join(tempDir, objectKey)
+
+	// A third of the way in is usually past the title card and into the first real visual.
+	offset := fmt.Sprintf("%d", duration/3)
+	cmd := exec.Command("ffmpeg", "-y", "-ss", offset, "-i", filepath.Join(dir, videoPath), "-frames:v", "1", "-q:v", "3", thumbPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", 0, fmt.Errorf("ffmpeg thumbnail failed: %v\nOutput: %s", err, string(output))
+	}
+
+	file, err := os.Open(thumbPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("could not open thumbnail: %v", err)
+	}
+	defer file.Close()
+
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	if err := putObject(file, objectKey, contentTypes[".jpg"]); err != nil {
+		return "", 0, err
+	}
+
+	url := objectURL(objectKey)
+	if err := database.DB.Model(&models.RenderCache{}).Where("object_key = ?", videoKey).
+		Update("thumbnail_url", url).Error; err != nil {
+		fmt.Printf("Warning: failed to update render cache entry: %v\n", err)
 	}
 
-	return fmt.Sprintf("https://feynman-bot.s3.ap-south-1.amazonaws.com/%s", objectKey), nil
+	return url, size, nil
 }
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const webhookTolerance = 5 * time.Minute
+
+func VerifySvixSignature(secret string, header http.Header, body []byte, now time.Time) error {
+	msgID := header.Get("svix-id")
+	timestamp := header.Get("svix-timestamp")
+	signatures := header.Get("svix-signature")
+	if msgID == "" || timestamp == "" || signatures == "" {
+		return fmt.Errorf("missing signature headers")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %v", err)
+	}
+	sent := time.Unix(seconds, 0)
+	if now.Sub(sent) > webhookTolerance || sent.Sub(now) > webhookTolerance {
+		return fmt.Errorf("timestamp outside tolerance")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return fmt.Errorf("invalid webhook secret: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msgID + "." + timestamp + "."))
+	mac.Write(body)
+	expected := []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	for _, candidate := range strings.Fields(signatures) {
+		version, signature, ok := strings.Cut(candidate, ",")
+		if ok && version == "v1" && hmac.Equal([]byte(signature), expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no matching signature")
+}